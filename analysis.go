@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// This file holds a minimal driver for go/analysis analyzers,
+// so that their suggested fixes can be used by fixers.
+// prbot doesn't have a checkout of the repo or its dependencies,
+// so only packages that type-check using the standard library alone can be analyzed.
+
+var (
+	importerMu  sync.Mutex
+	stdImporter = importer.Default()
+)
+
+// stdlibImporter is a types.Importer that is safe for concurrent use.
+type stdlibImporter struct{}
+
+func (stdlibImporter) Import(path string) (*types.Package, error) {
+	importerMu.Lock()
+	defer importerMu.Unlock()
+	return stdImporter.Import(path)
+}
+
+// parsePackages parses files, keyed by name, grouping them by package name,
+// since a directory can hold an external test package as well as its package.
+func parsePackages(fset *token.FileSet, files map[string][]byte) (map[string][]*ast.File, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pkgs := make(map[string][]*ast.File)
+	for _, name := range names {
+		f, err := parser.ParseFile(fset, name, files[name], parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		pkgs[f.Name.Name] = append(pkgs[f.Name.Name], f)
+	}
+	return pkgs, nil
+}
+
+// typeCheck type-checks files as a package.
+// It returns an error if the package has any type errors,
+// for instance because it imports something outside the standard library.
+func typeCheck(fset *token.FileSet, files []*ast.File) (*types.Package, *types.Info, error) {
+	info := newTypesInfo()
+	conf := &types.Config{Importer: stdlibImporter{}}
+	pkg, err := conf.Check(files[0].Name.Name, fset, files, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pkg, info, nil
+}
+
+// typeCheckPartial type-checks files as a package as far as it can,
+// carrying on past type errors. The types of expressions that depend
+// on packages outside the standard library are invalid.
+func typeCheckPartial(fset *token.FileSet, files []*ast.File) *types.Info {
+	info := newTypesInfo()
+	conf := &types.Config{
+		Importer: stdlibImporter{},
+		Error:    func(error) {},
+	}
+	conf.Check(files[0].Name.Name, fset, files, info)
+	return info
+}
+
+func newTypesInfo() *types.Info {
+	return &types.Info{
+		Types:        make(map[ast.Expr]types.TypeAndValue),
+		Instances:    make(map[*ast.Ident]types.Instance),
+		Defs:         make(map[*ast.Ident]types.Object),
+		Uses:         make(map[*ast.Ident]types.Object),
+		Implicits:    make(map[ast.Node]types.Object),
+		Selections:   make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:       make(map[ast.Node]*types.Scope),
+		FileVersions: make(map[*ast.File]string),
+	}
+}
+
+// analysisRun runs analyzers over a single type-checked package.
+type analysisRun struct {
+	fset  *token.FileSet
+	files []*ast.File
+	pkg   *types.Package
+	info  *types.Info
+
+	results  map[*analysis.Analyzer]interface{}
+	objFacts map[factKey]analysis.Fact
+	pkgFacts map[factKey]analysis.Fact
+}
+
+type factKey struct {
+	obj interface{} // types.Object or *types.Package
+	typ reflect.Type
+}
+
+func newAnalysisRun(fset *token.FileSet, files []*ast.File, pkg *types.Package, info *types.Info) *analysisRun {
+	return &analysisRun{
+		fset:     fset,
+		files:    files,
+		pkg:      pkg,
+		info:     info,
+		results:  make(map[*analysis.Analyzer]interface{}),
+		objFacts: make(map[factKey]analysis.Fact),
+		pkgFacts: make(map[factKey]analysis.Fact),
+	}
+}
+
+// diagnostics runs a, and the analyzers it requires, returning the diagnostics reported by a.
+func (r *analysisRun) diagnostics(a *analysis.Analyzer) ([]analysis.Diagnostic, error) {
+	var diags []analysis.Diagnostic
+	_, err := r.run(a, func(d analysis.Diagnostic) { diags = append(diags, d) })
+	return diags, err
+}
+
+func (r *analysisRun) run(a *analysis.Analyzer, report func(analysis.Diagnostic)) (res interface{}, err error) {
+	if res, ok := r.results[a]; ok && report == nil {
+		return res, nil
+	}
+	resultOf := make(map[*analysis.Analyzer]interface{})
+	for _, req := range a.Requires {
+		res, err := r.run(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		resultOf[req] = res
+	}
+	if report == nil {
+		report = func(analysis.Diagnostic) {}
+	}
+	pass := &analysis.Pass{
+		Analyzer:   a,
+		Fset:       r.fset,
+		Files:      r.files,
+		Pkg:        r.pkg,
+		TypesInfo:  r.info,
+		TypesSizes: types.SizesFor("gc", "amd64"),
+		Report:     report,
+		ResultOf:   resultOf,
+		ReadFile: func(filename string) ([]byte, error) {
+			return nil, fmt.Errorf("cannot read %s", filename)
+		},
+		ImportObjectFact:  func(obj types.Object, fact analysis.Fact) bool { return importFact(r.objFacts, obj, fact) },
+		ImportPackageFact: func(pkg *types.Package, fact analysis.Fact) bool { return importFact(r.pkgFacts, pkg, fact) },
+		ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+			r.objFacts[factKey{obj, reflect.TypeOf(fact)}] = fact
+		},
+		ExportPackageFact: func(fact analysis.Fact) {
+			r.pkgFacts[factKey{r.pkg, reflect.TypeOf(fact)}] = fact
+		},
+		AllObjectFacts: func() []analysis.ObjectFact {
+			var facts []analysis.ObjectFact
+			for k, f := range r.objFacts {
+				facts = append(facts, analysis.ObjectFact{Object: k.obj.(types.Object), Fact: f})
+			}
+			return facts
+		},
+		AllPackageFacts: func() []analysis.PackageFact {
+			var facts []analysis.PackageFact
+			for k, f := range r.pkgFacts {
+				facts = append(facts, analysis.PackageFact{Package: k.obj.(*types.Package), Fact: f})
+			}
+			return facts
+		},
+	}
+	defer func() {
+		// Analyzers are written for well-formed programs
+		// and may panic on code they don't expect.
+		if e := recover(); e != nil {
+			err = fmt.Errorf("analyzer %s panicked: %v", a.Name, e)
+		}
+	}()
+	res, err = a.Run(pass)
+	if err != nil {
+		return nil, fmt.Errorf("analyzer %s: %v", a.Name, err)
+	}
+	r.results[a] = res
+	return res, nil
+}
+
+func importFact(facts map[factKey]analysis.Fact, obj interface{}, fact analysis.Fact) bool {
+	f, ok := facts[factKey{obj, reflect.TypeOf(fact)}]
+	if ok {
+		reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(f).Elem())
+	}
+	return ok
+}
+
+// applyFixes applies the first suggested fix of each diagnostic to src,
+// which is the content of the file tf. Fixes that overlap an earlier one are dropped.
+// It returns the new source and the number of fixes applied.
+func applyFixes(tf *token.File, src []byte, diags []analysis.Diagnostic) ([]byte, int) {
+	type edit struct {
+		start, end int
+		text       []byte
+	}
+	type fix struct {
+		edits []edit
+	}
+	var fixes []fix
+	for _, d := range diags {
+		if len(d.SuggestedFixes) == 0 {
+			continue
+		}
+		var f fix
+		ok := true
+		for _, te := range d.SuggestedFixes[0].TextEdits {
+			end := te.End
+			if !end.IsValid() {
+				end = te.Pos
+			}
+			if !inFile(tf, te.Pos) || !inFile(tf, end) {
+				ok = false
+				break
+			}
+			f.edits = append(f.edits, edit{tf.Offset(te.Pos), tf.Offset(end), te.NewText})
+		}
+		if ok && len(f.edits) > 0 {
+			fixes = append(fixes, f)
+		}
+	}
+
+	// Accept whole fixes in order, skipping any that overlap an accepted one.
+	var edits []edit
+	overlaps := func(e edit) bool {
+		for _, prev := range edits {
+			if e.start < prev.end && prev.start < e.end || e.start == prev.start {
+				return true
+			}
+		}
+		return false
+	}
+	n := 0
+	for _, f := range fixes {
+		ok := true
+		for _, e := range f.edits {
+			if overlaps(e) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			edits = append(edits, f.edits...)
+			n++
+		}
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var out []byte
+	last := 0
+	for _, e := range edits {
+		out = append(out, src[last:e.start]...)
+		out = append(out, e.text...)
+		last = e.end
+	}
+	out = append(out, src[last:]...)
+	return out, n
+}
+
+func inFile(tf *token.File, pos token.Pos) bool {
+	return pos.IsValid() && tf.Base() <= int(pos) && int(pos) <= tf.Base()+tf.Size()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// tokenSource returns the source of the tokens to authenticate to GitHub with:
+// a GitHub App installation if -app-id is set, or else a personal access token.
+func tokenSource() (oauth2.TokenSource, error) {
+	if *appID != 0 || *installationID != 0 || *appKeyFile != "" {
+		if *appID == 0 || *installationID == 0 || *appKeyFile == "" {
+			return nil, errors.New("-app-id, -installation-id and -app-private-key-file must be used together")
+		}
+		src, err := newAppTokenSource(*appID, *installationID, *appKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		// Installation tokens expire after an hour; get a new one when that happens.
+		return oauth2.ReuseTokenSource(nil, src), nil
+	}
+	token, err := authToken()
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+}
+
+// checkScopes checks that the personal access token gh authenticates with
+// can make pull requests, so that prbot doesn't fail halfway through.
+// Fine-grained tokens don't report their permissions, so they pass unchecked.
+func checkScopes(ctx context.Context, gh *github.Client) error {
+	_, resp, err := gh.Users.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("getting authenticated user: %v", err)
+	}
+	header := resp.Header.Get("X-OAuth-Scopes")
+	if header == "" {
+		return nil
+	}
+	for _, scope := range strings.Split(header, ",") {
+		switch strings.TrimSpace(scope) {
+		case "repo", "public_repo":
+			return nil
+		}
+	}
+	return fmt.Errorf("token has scopes %q, but needs repo, or public_repo for public repos", header)
+}
+
+// authToken finds the GitHub personal access token to use.
+// In order of preference, it is read from the -token-file flag,
+// the PRBOT_TOKEN environment variable, or $HOME/.prbot-token.
+func authToken() (string, error) {
+	if *tokenFile != "" {
+		return readToken(*tokenFile)
+	}
+	if tok := os.Getenv("PRBOT_TOKEN"); tok != "" {
+		return strings.TrimSpace(tok), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		// In a container, say.
+		return "", fmt.Errorf("no token found; pass -token-file or set $PRBOT_TOKEN (%v)", err)
+	}
+	name := filepath.Join(home, ".prbot-token")
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return "", fmt.Errorf("no token found; pass -token-file, set $PRBOT_TOKEN, or create %s", name)
+	}
+	return readToken(name)
+}
+
+// readToken reads a token from the named file.
+func readToken(name string) (string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	tok := strings.TrimSpace(string(data))
+	if tok == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	return tok, nil
+}
+
+// appTokenSource is an oauth2.TokenSource for the access tokens
+// of an installation of a GitHub App.
+type appTokenSource struct {
+	appID, installationID int64
+	key                   *rsa.PrivateKey
+}
+
+func newAppTokenSource(appID, installationID int64, keyFile string) (*appTokenSource, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM data", keyFile)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		k, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("parsing private key in %s: %v", keyFile, err)
+		}
+		var ok bool
+		if key, ok = k.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("private key in %s is not an RSA key", keyFile)
+		}
+	}
+	return &appTokenSource{appID: appID, installationID: installationID, key: key}, nil
+}
+
+// Token exchanges a JWT signed with the app's private key
+// for a new installation access token.
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	gh, err := newClient(nil)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("app/installations/%d/access_tokens", s.installationID)
+	req, err := gh.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+	var tok github.InstallationToken
+	if _, err := gh.Do(context.Background(), req, &tok); err != nil {
+		return nil, fmt.Errorf("getting installation access token: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: tok.GetToken(),
+		Expiry:      tok.GetExpiresAt(),
+	}, nil
+}
+
+// jwt returns a JSON Web Token identifying the app, valid for a few minutes from now.
+func (s *appTokenSource) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock skew
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(s.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// The REST API has no way to turn on auto-merge, so it takes GraphQL.
+
+// mergeMethods maps the values of -auto-merge-method to GraphQL's.
+var mergeMethods = map[string]githubv4.PullRequestMergeMethod{
+	"merge":  githubv4.PullRequestMergeMethodMerge,
+	"squash": githubv4.PullRequestMergeMethodSquash,
+	"rebase": githubv4.PullRequestMergeMethodRebase,
+}
+
+// newGraphQLClient returns a GraphQL client for the GitHub instance
+// that -github-url points to.
+func newGraphQLClient(httpClient *http.Client) *githubv4.Client {
+	if *githubURL == "" {
+		return githubv4.NewClient(httpClient)
+	}
+	// GitHub Enterprise Server serves the REST API under /api/v3/
+	// and GraphQL at /api/graphql.
+	u := strings.TrimSuffix(*githubURL, "/")
+	u = strings.TrimSuffix(u, "/v3") + "/graphql"
+	return githubv4.NewEnterpriseClient(u, httpClient)
+}
+
+// enableAutoMerge has GitHub merge the pull request with GraphQL ID nodeID,
+// using method, once its required checks pass.
+func enableAutoMerge(ctx context.Context, v4 *githubv4.Client, nodeID string, method githubv4.PullRequestMergeMethod) error {
+	var m struct {
+		EnablePullRequestAutoMerge struct {
+			ClientMutationID string
+		} `graphql:"enablePullRequestAutoMerge(input: $input)"`
+	}
+	return v4.Mutate(ctx, &m, githubv4.EnablePullRequestAutoMergeInput{
+		PullRequestID: githubv4.ID(nodeID),
+		MergeMethod:   &method,
+	}, nil)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// blobCache keeps blobs on disk between runs, keyed by their SHA-1,
+// so that files that haven't changed since the last run aren't fetched again.
+// Since a blob's SHA-1 names its content, entries never go stale,
+// but those older than maxAge, if not zero, are fetched again anyway
+// so that a damaged entry doesn't linger. A nil *blobCache caches nothing.
+type blobCache struct {
+	dir    string
+	maxAge time.Duration
+}
+
+// get returns the blob sha1 from the cache, reporting whether it was there.
+func (c *blobCache) get(sha1 string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	name := filepath.Join(c.dir, sha1)
+	fi, err := os.Stat(name)
+	if err != nil || c.maxAge > 0 && time.Since(fi.ModTime()) > c.maxAge {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// put adds the blob sha1 to the cache.
+func (c *blobCache) put(sha1 string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return err
+	}
+	// Write to a temporary file first,
+	// so that another prbot never reads a partly written blob.
+	f, err := ioutil.TempFile(c.dir, sha1+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, sha1))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
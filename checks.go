@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// checkRunName is the name of the check runs posted with -github-checks-api.
+const checkRunName = "prbot/gofmt"
+
+// maxAnnotations is the most annotations GitHub takes in one request.
+const maxAnnotations = 50
+
+// go-github's check run types predate the API's final form,
+// which names annotations' fields differently, so these are its own.
+
+type checkRunOptions struct {
+	Name       string          `json:"name,omitempty"`
+	HeadSHA    string          `json:"head_sha,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	Conclusion string          `json:"conclusion,omitempty"`
+	Output     *checkRunOutput `json:"output,omitempty"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Annotations []checkRunAnnotation `json:"annotations,omitempty"`
+}
+
+type checkRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Message         string `json:"message"`
+}
+
+type checkRun struct {
+	ID      int64  `json:"id"`
+	HTMLURL string `json:"html_url"`
+}
+
+// postCheckRun posts a completed check run on commit sha of owner/repo,
+// which fails with an annotation on each of diffs, or succeeds if there are none.
+// Only GitHub Apps may post check runs.
+func postCheckRun(ctx context.Context, gh *github.Client, owner, repo, sha, fixer string, diffs []DiffEntry) (*checkRun, error) {
+	opts := checkRunOptions{
+		Name:       checkRunName,
+		HeadSHA:    sha,
+		Status:     "completed",
+		Conclusion: "success",
+		Output: &checkRunOutput{
+			Title:   "No files need changes",
+			Summary: fmt.Sprintf("Running %s changes nothing.", fixer),
+		},
+	}
+	var anns []checkRunAnnotation
+	if len(diffs) > 0 {
+		for _, d := range diffs {
+			line := d.FirstChangedLine()
+			anns = append(anns, checkRunAnnotation{
+				Path:            d.Path,
+				StartLine:       line,
+				EndLine:         line,
+				AnnotationLevel: "failure",
+				Message:         fmt.Sprintf("File is not formatted with %s.", fixer),
+			})
+		}
+		opts.Conclusion = "failure"
+		opts.Output.Title = fmt.Sprintf("%d %s need changes", len(diffs), plural(len(diffs), "file", "files"))
+		var sum strings.Builder
+		fmt.Fprintf(&sum, "Running %s changes these files:\n\n", fixer)
+		for _, d := range diffs {
+			fmt.Fprintf(&sum, "- `%s`\n", d.Path)
+		}
+		opts.Output.Summary = sum.String()
+		opts.Output.Annotations = anns[:min(len(anns), maxAnnotations)]
+	}
+
+	var run checkRun
+	err := withRetry(ctx, *maxRetries, func() error {
+		req, err := gh.NewRequest("POST", fmt.Sprintf("repos/%v/%v/check-runs", owner, repo), opts)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		_, err = gh.Do(ctx, req, &run)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating check run: %v", err)
+	}
+
+	// The rest of the annotations are added to the run a batch at a time.
+	for i := maxAnnotations; i < len(anns); i += maxAnnotations {
+		update := checkRunOptions{Output: &checkRunOutput{
+			Title:       opts.Output.Title,
+			Summary:     opts.Output.Summary,
+			Annotations: anns[i:min(len(anns), i+maxAnnotations)],
+		}}
+		err := withRetry(ctx, *maxRetries, func() error {
+			req, err := gh.NewRequest("PATCH", fmt.Sprintf("repos/%v/%v/check-runs/%d", owner, repo, run.ID), update)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Accept", "application/vnd.github+json")
+			_, err = gh.Do(ctx, req, nil)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("adding annotations to check run: %v", err)
+		}
+	}
+	return &run, nil
+}
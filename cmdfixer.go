@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CmdFixer is a Fixer that runs an external command.
+// By default the command reads the source on stdin and writes the fixed source to stdout.
+// If InPlace is set, the source is instead written to a temporary file,
+// whose name is appended to the command's arguments, and read back
+// once the command has rewritten it.
+// A command that exits with a non-zero status could not fix the file,
+// and one still running once Ctx is done is killed.
+type CmdFixer struct {
+	Ctx     context.Context
+	Args    []string
+	InPlace bool
+}
+
+func (f CmdFixer) Name() string { return filepath.Base(f.Args[0]) }
+
+func (f CmdFixer) Fix(filename string, src []byte) ([]byte, error) {
+	if !f.InPlace {
+		cmd := f.command(f.Args[1:]...)
+		cmd.Stdin = bytes.NewReader(src)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, cmdError(f.Args[0], err, stderr.Bytes())
+		}
+		return stdout.Bytes(), nil
+	}
+
+	// Keep the extension, since tools may go by it.
+	tmp, err := os.CreateTemp("", "prbot-*"+filepath.Ext(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	cmd := f.command(append(f.Args[1:], tmp.Name())...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, cmdError(f.Args[0], err, stderr.Bytes())
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+// command returns the command to run with args.
+func (f CmdFixer) command(args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(f.Ctx, f.Args[0], args...)
+	// Once the command is killed, don't wait long for any children it left holding stdout or stderr.
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+func cmdError(name string, err error, stderr []byte) error {
+	if msg := strings.TrimSpace(string(stderr)); msg != "" {
+		return fmt.Errorf("%s: %v: %s", name, err, msg)
+	}
+	return fmt.Errorf("%s: %v", name, err)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// codeownersPaths are where GitHub looks for a CODEOWNERS file, in order.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// A codeownersRule is a line of a CODEOWNERS file,
+// saying who owns the files that match pattern.
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+// fetchCodeowners reads the rules in the CODEOWNERS file in tree, the tree of github.com/owner/repo.
+// It returns no rules if there isn't one.
+func fetchCodeowners(ctx context.Context, gh *github.Client, owner, repo string, tree *github.Tree) ([]codeownersRule, error) {
+	for _, p := range codeownersPaths {
+		for _, te := range tree.Entries {
+			if te.GetPath() != p || te.GetType() != "blob" {
+				continue
+			}
+			var data []byte
+			err := withRetry(ctx, *maxRetries, func() (err error) {
+				data, err = rawBlob(ctx, gh, rl, owner, repo, te.GetSHA())
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			return parseCodeowners(data), nil
+		}
+	}
+	return nil, nil
+}
+
+func parseCodeowners(data []byte) []codeownersRule {
+	var rules []codeownersRule
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		rules = append(rules, codeownersRule{f[0], f[1:]})
+	}
+	return rules
+}
+
+// codeowners returns the users and org/teams that own the files in changes,
+// ignoring owners given by email address, which can't be asked for reviews.
+func codeowners(rules []codeownersRule, changes []github.TreeEntry) []string {
+	var owners []string
+	seen := make(map[string]bool)
+	for _, te := range changes {
+		// The last matching rule wins.
+		var rule *codeownersRule
+		for i := range rules {
+			if codeownersMatch(rules[i].pattern, te.GetPath()) {
+				rule = &rules[i]
+			}
+		}
+		if rule == nil {
+			continue
+		}
+		for _, o := range rule.owners {
+			if !strings.HasPrefix(o, "@") {
+				continue
+			}
+			o = o[1:]
+			if !seen[strings.ToLower(o)] {
+				seen[strings.ToLower(o)] = true
+				owners = append(owners, o)
+			}
+		}
+	}
+	return owners
+}
+
+// codeownersMatch reports whether the CODEOWNERS pattern matches the file at path p.
+// Patterns follow the rules of .gitignore files: a pattern is relative to the root
+// of the repo if it has a slash other than at the end, and otherwise matches at any depth,
+// and a pattern that matches a directory matches all the files under it.
+// Unlike in .gitignore files, though, a pattern ending in "/*" only matches
+// the files directly in the directory.
+func codeownersMatch(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return matchPath(strings.TrimPrefix(pattern, "/"), p)
+	}
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		pattern = "**/" + pattern
+	}
+	if matchPath(pattern+"/*/**", p) {
+		return true
+	}
+	return !dirOnly && matchPath(pattern, p)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestCodeownersMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*", "a.go", true},
+		{"*", "dir/a.go", true},
+		{"*.go", "dir/a.go", true},
+		{"*.go", "a.md", false},
+		{"/a.go", "a.go", true},
+		{"/a.go", "dir/a.go", false},
+		{"docs/", "docs/a.md", true},
+		{"docs/", "dir/docs/a.md", true},
+		{"docs/", "docs", false},
+		{"/build/", "build/sub/a.go", true},
+		{"/build/", "dir/build/a.go", false},
+		{"apps", "apps/a.go", true},
+		{"apps", "apps", true},
+		{"docs/*", "docs/a.md", true},
+		{"docs/*", "docs/sub/a.md", false},
+		{"**/logs", "dir/logs/a.log", true},
+		{"dir/**/a.go", "dir/sub/sub/a.go", true},
+	}
+	for _, tt := range tests {
+		if got := codeownersMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("codeownersMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCodeowners(t *testing.T) {
+	rules := parseCodeowners([]byte(`# Owners
+*          @everyone
+*.md       @docs-team docs@example.com
+/cmd/      @org/cli @Everyone # shared
+/cmd/gen/
+`))
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"default", []string{"a.go"}, []string{"everyone"}},
+		{"email owners skipped", []string{"README.md"}, []string{"docs-team"}},
+		{"last rule wins", []string{"cmd/main.go"}, []string{"org/cli", "Everyone"}},
+		{"rule without owners", []string{"cmd/gen/gen.go"}, nil},
+		{"each owner once", []string{"a.go", "b.go", "cmd/main.go"}, []string{"everyone", "org/cli"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []github.TreeEntry
+			for _, p := range tt.paths {
+				changes = append(changes, github.TreeEntry{Path: github.String(p)})
+			}
+			if got := codeowners(rules, changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
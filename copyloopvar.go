@@ -0,0 +1,204 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// CopyLoopVarFixer removes copies of loop variables at the start of loop bodies, like
+// those the copyloopvar linter reports, such as te := te,
+// which were needed before Go 1.22 to give each iteration its own variable.
+// It only touches files in Modules whose go directive is at least 1.22,
+// since the copies still matter in older modules.
+type CopyLoopVarFixer struct {
+	Modules []goModule
+}
+
+func (CopyLoopVarFixer) Name() string { return "copyloopvar" }
+
+func (f CopyLoopVarFixer) Fix(filename string, src []byte) ([]byte, error) {
+	out, _, err := f.FixN(filename, src)
+	return out, err
+}
+
+func (f CopyLoopVarFixer) FixN(filename string, src []byte) ([]byte, int, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !goVersionAtLeast(filename, file, f.Modules, "go1.22") {
+		return src, 0, nil
+	}
+	tf := fset.File(file.Pos())
+
+	type cut struct{ start, end int }
+	var cuts []cut
+	ast.Inspect(file, func(n ast.Node) bool {
+		loopVars := make(map[string]bool)
+		var body *ast.BlockStmt
+		// Assigning to a copy changes only the copy,
+		// but assigning to a three-clause loop's variable changes the loop.
+		threeClause := false
+		switch loop := n.(type) {
+		case *ast.RangeStmt:
+			if loop.Tok != token.DEFINE {
+				return true
+			}
+			for _, e := range []ast.Expr{loop.Key, loop.Value} {
+				if id, ok := e.(*ast.Ident); ok && id.Name != "_" {
+					loopVars[id.Name] = true
+				}
+			}
+			body = loop.Body
+		case *ast.ForStmt:
+			init, ok := loop.Init.(*ast.AssignStmt)
+			if !ok || init.Tok != token.DEFINE {
+				return true
+			}
+			for _, e := range init.Lhs {
+				if id, ok := e.(*ast.Ident); ok && id.Name != "_" {
+					loopVars[id.Name] = true
+				}
+			}
+			body, threeClause = loop.Body, true
+		default:
+			return true
+		}
+		// Only the copies at the start of the body go: anything before a copy,
+		// such as a closure, may see the loop's variable rather than the copy.
+		for _, stmt := range body.List {
+			names, ok := loopVarCopy(stmt, loopVars)
+			if !ok {
+				break
+			}
+			if threeClause && anyModified(body, names) {
+				continue
+			}
+			start, end, ok := lineOf(src, tf.Offset(stmt.Pos()), tf.Offset(stmt.End()))
+			if ok {
+				cuts = append(cuts, cut{start, end})
+			}
+		}
+		return true
+	})
+	if len(cuts) == 0 {
+		return src, 0, nil
+	}
+
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i].start < cuts[j].start })
+	var out []byte
+	last := 0
+	for _, c := range cuts {
+		out = append(out, src[last:c.start]...)
+		last = c.end
+	}
+	out = append(out, src[last:]...)
+	return out, len(cuts), nil
+}
+
+// loopVarCopy reports whether stmt copies only variables in loopVars to new variables
+// of the same names, like i, v := i, v, and if so returns the names.
+func loopVarCopy(stmt ast.Stmt, loopVars map[string]bool) ([]string, bool) {
+	as, ok := stmt.(*ast.AssignStmt)
+	if !ok || !isSelfCopy(as) {
+		return nil, false
+	}
+	var names []string
+	for _, e := range as.Lhs {
+		name := e.(*ast.Ident).Name
+		if !loopVars[name] {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, true
+}
+
+// anyModified reports whether anything in body might change a variable named in names:
+// assigning to it, incrementing it, taking its address, or selecting from it,
+// which may call a method with a pointer receiver.
+func anyModified(body *ast.BlockStmt, names []string) bool {
+	named := func(e ast.Expr) bool {
+		id, ok := e.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		for _, name := range names {
+			if id.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, e := range n.Lhs {
+				// A copy declares a new variable rather than assigning to the loop's.
+				if named(e) && !isSelfCopy(n) {
+					found = true
+				}
+			}
+		case *ast.IncDecStmt:
+			found = found || named(n.X)
+		case *ast.UnaryExpr:
+			found = found || n.Op == token.AND && named(n.X)
+		case *ast.SelectorExpr:
+			found = found || named(n.X)
+		case *ast.RangeStmt:
+			found = found || n.Tok == token.ASSIGN && (named(n.Key) || named(n.Value))
+		}
+		return !found
+	})
+	return found
+}
+
+// isSelfCopy reports whether as is a statement like i, v := i, v.
+func isSelfCopy(as *ast.AssignStmt) bool {
+	if as.Tok != token.DEFINE || len(as.Lhs) != len(as.Rhs) {
+		return false
+	}
+	for i := range as.Lhs {
+		l, ok1 := as.Lhs[i].(*ast.Ident)
+		r, ok2 := as.Rhs[i].(*ast.Ident)
+		if !ok1 || !ok2 || l.Name != r.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// lineOf returns the span of src to cut to remove the statement at src[start:end],
+// which is the whole line it is on, along with any blank line after it at the start of a block,
+// reporting whether the statement is alone on its line.
+// A statement with a comment after it is left alone, since the comment would go too.
+func lineOf(src []byte, start, end int) (int, int, bool) {
+	lineStart := strings.LastIndexByte(string(src[:start]), '\n') + 1
+	if strings.TrimLeft(string(src[lineStart:start]), " \t") != "" {
+		return 0, 0, false
+	}
+	rest := string(src[end:])
+	nl := strings.IndexByte(rest, '\n')
+	if nl < 0 {
+		nl = len(rest)
+	} else {
+		nl++ // the newline goes too
+	}
+	if strings.TrimSpace(rest[:nl]) != "" {
+		return 0, 0, false
+	}
+	end += nl
+	// gofmt keeps a blank line at the start of a block, so one after a copy there goes too.
+	if strings.HasSuffix(strings.TrimRight(string(src[:lineStart]), " \t\n"), "{") {
+		next := string(src[end:])
+		if i := strings.IndexByte(next, '\n'); i >= 0 && strings.TrimSpace(next[:i]) == "" {
+			end += i + 1
+		}
+	}
+	return lineStart, end, true
+}
@@ -0,0 +1,139 @@
+package main
+
+import "testing"
+
+func TestCopyLoopVarFixer(t *testing.T) {
+	go122 := []goModule{{Root: ".", GoVersion: "1.22"}}
+	tests := []struct {
+		name string
+		mods []goModule
+		src  string
+		want string
+	}{
+		{
+			name: "range",
+			mods: go122,
+			src: `package p
+
+func f(s []int) {
+	for i, v := range s {
+		i, v := i, v
+		go println(i, v)
+	}
+}
+`,
+			want: `package p
+
+func f(s []int) {
+	for i, v := range s {
+		go println(i, v)
+	}
+}
+`,
+		},
+		{
+			name: "three-clause",
+			mods: go122,
+			src: `package p
+
+func f() {
+	for i := 0; i < 3; i++ {
+		i := i
+		go println(i)
+	}
+}
+`,
+			want: `package p
+
+func f() {
+	for i := 0; i < 3; i++ {
+		go println(i)
+	}
+}
+`,
+		},
+		{
+			name: "three-clause copy changed",
+			mods: go122,
+			src: `package p
+
+func f() {
+	for i := 0; i < 3; i++ {
+		i := i
+		i++
+		go println(i)
+	}
+}
+`,
+		},
+		{
+			name: "copy after a closure",
+			mods: go122,
+			src: `package p
+
+func f(s []int) {
+	for _, v := range s {
+		g := func() { println(v) }
+		v := v
+		g()
+		println(v)
+	}
+}
+`,
+		},
+		{
+			name: "comment after the copy",
+			mods: go122,
+			src: `package p
+
+func f(s []int) {
+	for _, v := range s {
+		v := v // capture
+		go println(v)
+	}
+}
+`,
+		},
+		{
+			name: "not a loop variable",
+			mods: go122,
+			src: `package p
+
+func f(s []int, x int) {
+	for range s {
+		x := x
+		go println(x)
+	}
+}
+`,
+		},
+		{
+			name: "old module",
+			mods: []goModule{{Root: ".", GoVersion: "1.21"}},
+			src: `package p
+
+func f(s []int) {
+	for _, v := range s {
+		v := v
+		go println(v)
+	}
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.src
+			}
+			got, err := CopyLoopVarFixer{Modules: tt.mods}.Fix("p.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// A DiffEntry is a file changed by the fixers, before and after.
+type DiffEntry struct {
+	Path        string
+	OriginalSHA string // the blob SHA of Original
+	Original    []byte
+	Fixed       []byte
+}
+
+// Diff returns a unified diff of the change to the file.
+func (d DiffEntry) Diff() (string, error) {
+	return unifiedDiff(d.Path, d.Original, d.Fixed)
+}
+
+// LineCounts returns the number of lines inserted into and deleted from the file,
+// counting a changed line as both, like git diff --stat.
+func (d DiffEntry) LineCounts() (inserted, deleted int) {
+	// A missing final newline makes the last line differ, which git counts as a change.
+	m := difflib.NewMatcher(splitLines(d.Original), splitLines(d.Fixed))
+	for _, op := range m.GetOpCodes() {
+		if op.Tag != 'e' {
+			deleted += op.I2 - op.I1
+			inserted += op.J2 - op.J1
+		}
+	}
+	return inserted, deleted
+}
+
+// FirstChangedLine returns the number, from 1, of the first line of the original file
+// that the fix changes, or the last line if the fix only adds lines at the end.
+func (d DiffEntry) FirstChangedLine() int {
+	a, b := strings.SplitAfter(string(d.Original), "\n"), strings.SplitAfter(string(d.Fixed), "\n")
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) || i == len(a)-1 && a[i] == "" {
+		// Lines were only added, after the last one.
+		i--
+	}
+	return max(i, 0) + 1
+}
+
+// diffStat returns a summary of diffs like that of git diff --stat,
+// with a line for each file and a total.
+func diffStat(diffs []DiffEntry) string {
+	const maxBar = 50
+	type stat struct {
+		path     string
+		ins, del int
+	}
+	stats := make([]stat, len(diffs))
+	width, most := 0, 0
+	var ins, del int
+	for i, d := range diffs {
+		s := stat{path: d.Path}
+		s.ins, s.del = d.LineCounts()
+		stats[i] = s
+		width = max(width, len(s.path))
+		most = max(most, s.ins+s.del)
+		ins += s.ins
+		del += s.del
+	}
+	var buf strings.Builder
+	for _, s := range stats {
+		plus, minus := s.ins, s.del
+		if most > maxBar {
+			// Scale the bars down, but keep them from vanishing.
+			plus = (plus*maxBar + most - 1) / most
+			minus = (minus*maxBar + most - 1) / most
+		}
+		fmt.Fprintf(&buf, " %-*s | %d %s%s\n", width, s.path, s.ins+s.del, strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	fmt.Fprintf(&buf, " %d %s changed, %d %s(+), %d %s(-)\n",
+		len(diffs), plural(len(diffs), "file", "files"),
+		ins, plural(ins, "insertion", "insertions"),
+		del, plural(del, "deletion", "deletions"))
+	return buf.String()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// unifiedDiff returns a git-style unified diff of the change
+// from a to b of the file at path.
+func unifiedDiff(path string, a, b []byte) (string, error) {
+	al, bl := splitLines(a), splitLines(b)
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "diff --git a/%s b/%s\n", path, path)
+	// line writes a line of a hunk, which, as the last of a file without a final newline,
+	// git marks as such.
+	line := func(prefix byte, l string) {
+		buf.WriteByte(prefix)
+		buf.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	header := false
+	for _, group := range difflib.NewMatcher(al, bl).GetGroupedOpCodes(3) {
+		if len(group) == 1 && group[0].Tag == 'e' {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", path, path)
+			header = true
+		}
+		first, last := group[0], group[len(group)-1]
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(first.I1, last.I2), hunkRange(first.J1, last.J2))
+		for _, op := range group {
+			if op.Tag == 'e' {
+				for _, l := range al[op.I1:op.I2] {
+					line(' ', l)
+				}
+				continue
+			}
+			if op.Tag == 'r' || op.Tag == 'd' {
+				for _, l := range al[op.I1:op.I2] {
+					line('-', l)
+				}
+			}
+			if op.Tag == 'r' || op.Tag == 'i' {
+				for _, l := range bl[op.J1:op.J2] {
+					line('+', l)
+				}
+			}
+		}
+	}
+	return buf.String(), nil
+}
+
+// hunkRange formats the lines [start, stop) for a hunk header, as start,length,
+// or just start if there is one line. An empty range starts at the line before it.
+func hunkRange(start, stop int) string {
+	begin, length := start+1, stop-start
+	if length == 1 {
+		return fmt.Sprint(begin)
+	}
+	if length == 0 {
+		begin--
+	}
+	return fmt.Sprintf("%d,%d", begin, length)
+}
+
+// splitLines splits s into lines, each ending in a newline but perhaps the last,
+// if s doesn't end in one.
+// Unlike difflib.SplitLines, it doesn't add an empty line after a final newline,
+// which would make the diff's line counts wrong for git apply.
+func splitLines(s []byte) []string {
+	lines := strings.SplitAfter(string(s), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	return lines
+}
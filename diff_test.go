@@ -0,0 +1,153 @@
+package main
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: `diff --git a/p.go b/p.go
+--- a/p.go
++++ b/p.go
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`,
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			want: `diff --git a/p.go b/p.go
+--- a/p.go
++++ b/p.go
+@@ -1,4 +1,4 @@
+-1
++x
+ 2
+ 3
+ 4
+@@ -7,4 +7,4 @@
+ 7
+ 8
+ 9
+-10
++y
+`,
+		},
+		{
+			name: "new file",
+			a:    "",
+			b:    "a\n",
+			want: `diff --git a/p.go b/p.go
+--- a/p.go
++++ b/p.go
+@@ -0,0 +1 @@
++a
+`,
+		},
+		{
+			name: "final newline added",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: `diff --git a/p.go b/p.go
+--- a/p.go
++++ b/p.go
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+		{
+			name: "no final newline in either",
+			a:    "a\nb",
+			b:    "A\nb",
+			want: `diff --git a/p.go b/p.go
+--- a/p.go
++++ b/p.go
+@@ -1,2 +1,2 @@
+-a
++A
+ b
+\ No newline at end of file
+`,
+		},
+		{
+			name: "unchanged",
+			a:    "a\n",
+			b:    "a\n",
+			want: "diff --git a/p.go b/p.go\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffEntry{Path: "p.go", Original: []byte(tt.a), Fixed: []byte(tt.b)}.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineCounts(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		ins, del int
+	}{
+		{"a\nb\n", "a\nb\n", 0, 0},
+		{"a\nb\n", "a\nB\n", 1, 1},
+		{"a\n", "a\nb\nc\n", 2, 0},
+		{"a\nb\nc\n", "c\n", 0, 2},
+		{"a\nb", "a\nb\n", 1, 1},
+	}
+	for _, tt := range tests {
+		ins, del := DiffEntry{Original: []byte(tt.a), Fixed: []byte(tt.b)}.LineCounts()
+		if ins != tt.ins || del != tt.del {
+			t.Errorf("LineCounts of %q to %q = %d, %d, want %d, %d", tt.a, tt.b, ins, del, tt.ins, tt.del)
+		}
+	}
+}
+
+func TestFirstChangedLine(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"a\nb\nc\n", "a\nB\nc\n", 2},
+		{"a\nb\n", "A\nb\n", 1},
+		{"a\nb\n", "a\nb\nc\n", 2},
+		{"a\nb", "a\nb\n", 2},
+	}
+	for _, tt := range tests {
+		if got := (DiffEntry{Original: []byte(tt.a), Fixed: []byte(tt.b)}).FirstChangedLine(); got != tt.want {
+			t.Errorf("FirstChangedLine of %q to %q = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	diffs := []DiffEntry{
+		{Path: "a.go", Original: []byte("a\nb\n"), Fixed: []byte("a\nB\n")},
+		{Path: "dir/b.go", Original: []byte("a\n"), Fixed: []byte("a\nb\n")},
+	}
+	want := ` a.go     | 2 +-
+ dir/b.go | 1 +
+ 2 files changed, 2 insertions(+), 1 deletion(-)
+`
+	if got := diffStat(diffs); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
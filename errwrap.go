@@ -0,0 +1,113 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ErrWrapFixer rewrites calls like fmt.Errorf("...: %v", err), where err is an error,
+// to use %w instead, so that errors.Is and errors.As can see the wrapped error.
+// This changes what the program does, so it is only run when asked for.
+// The type of an argument is often unknown, since prbot can only type-check
+// against the standard library, so an argument of unknown type named err counts as an error.
+type ErrWrapFixer struct{}
+
+func (ErrWrapFixer) Name() string { return "errwrap" }
+
+func (ErrWrapFixer) FixPackage(files map[string][]byte) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parsePackages(fset, files)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	for _, pfiles := range pkgs {
+		info := typeCheckPartial(fset, pfiles)
+		for _, f := range pfiles {
+			tf := fset.File(f.Pos())
+			src := files[tf.Name()]
+			var offsets []int // of the "%v"s to change
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || !isErrorf(info, call) {
+					return true
+				}
+				if off, ok := wrapOffset(tf, src, info, call); ok {
+					slog.Info("Wrapping error", "path", tf.Name(), "line", tf.Line(call.Pos()))
+					offsets = append(offsets, off)
+				}
+				return true
+			})
+			if len(offsets) == 0 {
+				continue
+			}
+			fixed := append([]byte(nil), src...)
+			for _, off := range offsets {
+				fixed[off+1] = 'w'
+			}
+			out[tf.Name()] = fixed
+		}
+	}
+	return out, nil
+}
+
+// isErrorf reports whether call is a call of fmt.Errorf.
+func isErrorf(info *types.Info, call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Errorf" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	pkg, ok := info.Uses[x].(*types.PkgName)
+	return ok && pkg.Imported().Path() == "fmt"
+}
+
+// wrapOffset returns the offset in src of the final %v in the format of a call of fmt.Errorf,
+// if that is the verb for the last argument, which is an error.
+func wrapOffset(tf *token.File, src []byte, info *types.Info, call *ast.CallExpr) (int, bool) {
+	if len(call.Args) < 2 || call.Ellipsis.IsValid() {
+		return 0, false
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return 0, false
+	}
+	format, err := strconv.Unquote(lit.Value)
+	if err != nil || strings.Contains(format, "%w") || strings.Contains(format, "%[") {
+		return 0, false
+	}
+	// The format must end in a %v verb, and not a literal %%v.
+	if !strings.HasSuffix(format, "%v") {
+		return 0, false
+	}
+	if percents := len(format) - 1 - len(strings.TrimRight(format[:len(format)-1], "%")); percents%2 == 0 {
+		return 0, false
+	}
+	// The source must end in %v too, and not some escape sequence meaning it.
+	end := tf.Offset(lit.End()) - 1 // of the closing quote
+	if end < 2 || string(src[end-2:end]) != "%v" {
+		return 0, false
+	}
+	if !isError(info, call.Args[len(call.Args)-1]) {
+		return 0, false
+	}
+	return end - 2, true
+}
+
+// isError reports whether x is an error. If the type of x is unknown,
+// it guesses from whether x is a variable named err.
+func isError(info *types.Info, x ast.Expr) bool {
+	t := info.TypeOf(x)
+	if t == nil || t == types.Typ[types.Invalid] {
+		id, ok := x.(*ast.Ident)
+		return ok && id.Name == "err"
+	}
+	return types.Implements(t, types.Universe.Lookup("error").Type().Underlying().(*types.Interface))
+}
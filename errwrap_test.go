@@ -0,0 +1,130 @@
+package main
+
+import "testing"
+
+func TestErrWrapFixer(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string // "" if the file is left alone
+	}{
+		{
+			name: "error",
+			src: `package p
+
+import "fmt"
+
+func f(err error) error {
+	return fmt.Errorf("reading: %v", err)
+}
+`,
+			want: `package p
+
+import "fmt"
+
+func f(err error) error {
+	return fmt.Errorf("reading: %w", err)
+}
+`,
+		},
+		{
+			name: "unknown type named err",
+			src: `package p
+
+import (
+	"fmt"
+
+	"example.com/other"
+)
+
+func f() error {
+	err := other.Do()
+	return fmt.Errorf("doing: %v", err)
+}
+`,
+			want: `package p
+
+import (
+	"fmt"
+
+	"example.com/other"
+)
+
+func f() error {
+	err := other.Do()
+	return fmt.Errorf("doing: %w", err)
+}
+`,
+		},
+		{
+			name: "not an error",
+			src: `package p
+
+import "fmt"
+
+func f(n int) error {
+	return fmt.Errorf("bad number: %v", n)
+}
+`,
+		},
+		{
+			name: "not the last verb",
+			src: `package p
+
+import "fmt"
+
+func f(err error, n int) error {
+	return fmt.Errorf("%v: got %d", err, n)
+}
+`,
+		},
+		{
+			name: "already wrapped",
+			src: `package p
+
+import "fmt"
+
+func f(err, err2 error) error {
+	return fmt.Errorf("%w: %v", err, err2)
+}
+`,
+		},
+		{
+			name: "escaped percent",
+			src: `package p
+
+import "fmt"
+
+func f(err error) error {
+	return fmt.Errorf("100%%v", err)
+}
+`,
+		},
+		{
+			name: "another Errorf",
+			src: `package p
+
+type logger struct{}
+
+func (logger) Errorf(format string, args ...any) error { return nil }
+
+func f(log logger, err error) error {
+	return log.Errorf("reading: %v", err)
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ErrWrapFixer{}.FixPackage(map[string][]byte{"p/a.go": []byte(tt.src)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{}
+			if tt.want != "" {
+				want["p/a.go"] = tt.want
+			}
+			checkPackageFix(t, got, want)
+		})
+	}
+}
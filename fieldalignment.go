@@ -0,0 +1,70 @@
+package main
+
+import (
+	"go/ast"
+	"go/format"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/fieldalignment"
+)
+
+// FieldAlignmentFixer reorders the fields of structs that would be smaller
+// in another order, using the fieldalignment analyzer.
+// It leaves alone structs whose layout only affects garbage collection,
+// and, since the analyzer's fixes drop comments, structs with comments.
+// Like staticcheck, it only covers packages that type-check against the standard library.
+type FieldAlignmentFixer struct{}
+
+func (FieldAlignmentFixer) Name() string { return "fieldalignment" }
+
+func (FieldAlignmentFixer) FixPackage(files map[string][]byte) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parsePackages(fset, files)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	for _, pfiles := range pkgs {
+		pkg, info, err := typeCheck(fset, pfiles)
+		if err != nil {
+			continue
+		}
+		diags, err := newAnalysisRun(fset, pfiles, pkg, info).diagnostics(fieldalignment.Analyzer)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range pfiles {
+			var keep []analysis.Diagnostic
+			for _, d := range diags {
+				if strings.Contains(d.Message, "optimal size") && !hasComments(f, d) {
+					keep = append(keep, d)
+				}
+			}
+			tf := fset.File(f.Pos())
+			src, n := applyFixes(tf, files[tf.Name()], keep)
+			if n == 0 {
+				continue
+			}
+			if out[tf.Name()], err = format.Source(src); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// hasComments reports whether any of the text that d's suggested fix replaces in f is a comment.
+func hasComments(f *ast.File, d analysis.Diagnostic) bool {
+	for _, fix := range d.SuggestedFixes {
+		for _, te := range fix.TextEdits {
+			for _, c := range f.Comments {
+				if c.Pos() < te.End && te.Pos < c.End() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/tools/imports"
+)
+
+// A Fixer rewrites the source of a single file.
+type Fixer interface {
+	// Name is the name the fixer goes by in logs and pull requests.
+	Name() string
+	// Fix returns the new source, which may be the same as src.
+	Fix(filename string, src []byte) ([]byte, error)
+}
+
+// A PackageFixer rewrites the Go files in a directory together,
+// for instance because it needs type information.
+type PackageFixer interface {
+	// Name is the name the fixer goes by in logs and pull requests.
+	Name() string
+	// FixPackage is given the sources of the Go files in a directory, keyed by path,
+	// and returns the new sources of those it changes.
+	FixPackage(files map[string][]byte) (map[string][]byte, error)
+}
+
+// A CountingFixer is a Fixer that can also say how many separate fixes it made to a file.
+type CountingFixer interface {
+	Fixer
+	FixN(filename string, src []byte) ([]byte, int, error)
+}
+
+// fixers holds the known fixers, keyed by the name used by the -fixer flag.
+var fixers = map[string]Fixer{
+	"gofmt":     GofmtFixer{},
+	"goimports": GoimportsFixer{},
+}
+
+// GofmtFixer formats Go source files like gofmt.
+type GofmtFixer struct{}
+
+func (GofmtFixer) Name() string { return "gofmt" }
+
+func (GofmtFixer) Fix(filename string, src []byte) ([]byte, error) {
+	return format.Source(src)
+}
+
+// SimplifyFixer simplifies and formats Go source files like gofmt -s.
+type SimplifyFixer struct{}
+
+func (SimplifyFixer) Name() string { return "gofmt -s" }
+
+func (SimplifyFixer) Fix(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	simplify(f)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RewriteFixer applies a rewrite rule to Go source files like gofmt -r.
+type RewriteFixer struct {
+	Rule             string
+	Pattern, Replace ast.Expr
+}
+
+// newRewriteFixer returns a RewriteFixer for a rule of the form 'pattern -> replacement'.
+func newRewriteFixer(rule string) (RewriteFixer, error) {
+	f := strings.Split(rule, "->")
+	if len(f) != 2 {
+		return RewriteFixer{}, fmt.Errorf("rewrite rule must be of the form 'pattern -> replacement'")
+	}
+	pattern, err := parseExpr(f[0], "pattern")
+	if err != nil {
+		return RewriteFixer{}, err
+	}
+	replace, err := parseExpr(f[1], "replacement")
+	if err != nil {
+		return RewriteFixer{}, err
+	}
+	return RewriteFixer{rule, pattern, replace}, nil
+}
+
+func (r RewriteFixer) Name() string { return fmt.Sprintf("gofmt -r '%s'", r.Rule) }
+
+func (r RewriteFixer) Fix(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	f = rewriteFile(fset, r.Pattern, r.Replace, f)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GoimportsFixer formats Go source files and fixes their imports like goimports.
+type GoimportsFixer struct{}
+
+func (GoimportsFixer) Name() string { return "goimports" }
+
+func (GoimportsFixer) Fix(filename string, src []byte) ([]byte, error) {
+	return imports.Process(filename, src, nil)
+}
+
+// TrailingWhitespaceFixer strips trailing whitespace from text files.
+type TrailingWhitespaceFixer struct{}
+
+func (TrailingWhitespaceFixer) Name() string { return "trim-whitespace" }
+
+func (TrailingWhitespaceFixer) Fix(filename string, src []byte) ([]byte, error) {
+	return trimTrailingWhitespace(filename, src)
+}
+
+// trimTrailingWhitespace strips trailing spaces and tabs
+// from each line of a text file. It leaves binary files alone.
+func trimTrailingWhitespace(filename string, src []byte) ([]byte, error) {
+	if isBinary(src) {
+		return src, nil
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		text := bytes.TrimRight(line, "\r\n")
+		out.Write(bytes.TrimRight(text, " \t"))
+		out.Write(line[len(text):])
+	}
+	return out.Bytes(), nil
+}
+
+// isBinary reports whether data looks like the contents of a binary file.
+// Like git, it looks for a NUL byte in the first few bytes.
+func isBinary(data []byte) bool {
+	if len(data) > 512 {
+		data = data[:512]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// generatedRE matches the comment that marks a generated file,
+// as described at https://golang.org/s/generatedcode.
+var generatedRE = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether src is the contents of a generated file.
+// Like isBinary, it only looks at the first few bytes, where the comment normally is.
+func isGenerated(src []byte) bool {
+	if len(src) > 512 {
+		src = src[:512]
+	}
+	return generatedRE.Match(src)
+}
+
+// ChainFixers returns a Fixer that applies each of fixers in turn,
+// passing the output of one to the next.
+// It stops at the first error.
+func ChainFixers(fixers ...Fixer) Fixer {
+	return chain(fixers)
+}
+
+type chain []Fixer
+
+func (c chain) Name() string {
+	names := make([]string, len(c))
+	for i, fix := range c {
+		names[i] = fix.Name()
+	}
+	return strings.Join(names, " and ")
+}
+
+func (c chain) Fix(filename string, src []byte) ([]byte, error) {
+	for _, fix := range c {
+		var err error
+		src, err = fix.Fix(filename, src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// ParallelFixer returns a Fixer that applies each of fixers to the same source at once,
+// for fixers that don't depend on each other's output, then merges their changes
+// in the order given. A fixer whose changes overlap those of an earlier one
+// is applied again to the merged source instead, so that the result is
+// the same as that of applying the fixers in turn.
+func ParallelFixer(fixers ...Fixer) Fixer {
+	return parallel(fixers)
+}
+
+type parallel []Fixer
+
+func (p parallel) Name() string { return chain(p).Name() }
+
+func (p parallel) Fix(filename string, src []byte) ([]byte, error) {
+	outs := make([][]byte, len(p))
+	errs := make([]error, len(p))
+	var wg sync.WaitGroup
+	for i, fix := range p {
+		i, fix := i, fix
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outs[i], errs[i] = fix.Fix(filename, src)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type edit struct {
+		start, end int // the lines of src replaced
+		lines      []string
+	}
+	var edits []edit
+	var redo []Fixer
+	lines := strings.SplitAfter(string(src), "\n")
+	for i, out := range outs {
+		if bytes.Equal(src, out) {
+			continue
+		}
+		outLines := strings.SplitAfter(string(out), "\n")
+		var these []edit
+		for _, op := range difflib.NewMatcher(lines, outLines).GetOpCodes() {
+			if op.Tag != 'e' {
+				these = append(these, edit{op.I1, op.I2, outLines[op.J1:op.J2]})
+			}
+		}
+		overlaps := false
+		for _, e := range these {
+			for _, prev := range edits {
+				if e.start < prev.end && prev.start < e.end || e.start == prev.start {
+					overlaps = true
+				}
+			}
+		}
+		if overlaps {
+			redo = append(redo, p[i])
+			continue
+		}
+		edits = append(edits, these...)
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var buf strings.Builder
+	last := 0
+	for _, e := range edits {
+		buf.WriteString(strings.Join(lines[last:e.start], ""))
+		buf.WriteString(strings.Join(e.lines, ""))
+		last = e.end
+	}
+	buf.WriteString(strings.Join(lines[last:], ""))
+	out := []byte(buf.String())
+	for _, fix := range redo {
+		// Its changes were counted the first time around.
+		if c, ok := fix.(counter); ok {
+			fix = c.Fixer
+		}
+		var err error
+		out, err = fix.Fix(filename, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// checkIdempotent returns a Fixer that applies fix,
+// failing if applying fix again to the result would change it still more.
+// A formatter that isn't stable on a file has likely mangled it.
+func checkIdempotent(fix Fixer) Fixer {
+	return idempotent{fix}
+}
+
+type idempotent struct {
+	Fixer
+}
+
+func (f idempotent) Fix(filename string, src []byte) ([]byte, error) {
+	out, err := f.Fixer.Fix(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	again, err := f.Fixer.Fix(filename, out)
+	if err != nil {
+		return nil, fmt.Errorf("%s fails on its own output: %v", f.Name(), err)
+	}
+	if !bytes.Equal(out, again) {
+		return nil, fmt.Errorf("%s changes its own output", f.Name())
+	}
+	return out, nil
+}
+
+// countChanges returns a Fixer that applies fix,
+// atomically incrementing *n whenever that changes a file.
+// If fix is a CountingFixer, it also adds the number of fixes it makes to *fixes.
+func countChanges(fix Fixer, n, fixes *int64) Fixer {
+	return counter{fix, n, fixes}
+}
+
+type counter struct {
+	Fixer
+	n, fixes *int64
+}
+
+func (c counter) Fix(filename string, src []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	if cf, ok := c.Fixer.(CountingFixer); ok {
+		var n int
+		out, n, err = cf.FixN(filename, src)
+		if err == nil {
+			atomic.AddInt64(c.fixes, int64(n))
+		}
+	} else {
+		out, err = c.Fixer.Fix(filename, src)
+	}
+	if err == nil && !bytes.Equal(src, out) {
+		atomic.AddInt64(c.n, 1)
+	}
+	return out, err
+}
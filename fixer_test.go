@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// replaceFixer replaces old with new wherever it appears.
+type replaceFixer struct{ old, new string }
+
+func (f replaceFixer) Name() string { return "replace " + f.old }
+
+func (f replaceFixer) Fix(filename string, src []byte) ([]byte, error) {
+	return []byte(strings.ReplaceAll(string(src), f.old, f.new)), nil
+}
+
+// failingFixer fails to fix anything.
+type failingFixer struct{}
+
+func (failingFixer) Name() string { return "fail" }
+
+func (failingFixer) Fix(filename string, src []byte) ([]byte, error) {
+	return nil, errors.New("failed")
+}
+
+func TestParallelFixer(t *testing.T) {
+	tests := []struct {
+		name   string
+		fixers []Fixer
+		src    string
+		want   string
+	}{
+		{
+			name:   "separate lines",
+			fixers: []Fixer{replaceFixer{"a", "A"}, replaceFixer{"c", "C"}},
+			src:    "a\nb\nc\n",
+			want:   "A\nb\nC\n",
+		},
+		{
+			name:   "same line",
+			fixers: []Fixer{replaceFixer{"a", "A"}, replaceFixer{"b", "B"}},
+			src:    "a b\nc\n",
+			want:   "A B\nc\n",
+		},
+		{
+			// Applied in turn, the first change leaves nothing for the second to do.
+			name:   "overlapping changes",
+			fixers: []Fixer{replaceFixer{"a", "b"}, replaceFixer{"a", "c"}},
+			src:    "a\nd\n",
+			want:   "b\nd\n",
+		},
+		{
+			name:   "no changes",
+			fixers: []Fixer{replaceFixer{"x", "X"}, replaceFixer{"y", "Y"}},
+			src:    "a\nb\n",
+			want:   "a\nb\n",
+		},
+		{
+			name:   "no final newline",
+			fixers: []Fixer{replaceFixer{"a", "A"}, replaceFixer{"b", "B"}},
+			src:    "a\nb",
+			want:   "A\nB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParallelFixer(tt.fixers...).Fix("p.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParallelFixerError(t *testing.T) {
+	_, err := ParallelFixer(replaceFixer{"a", "A"}, failingFixer{}).Fix("p.go", []byte("a\n"))
+	if err == nil {
+		t.Error("got no error from a failing fixer")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag.Value for a size in bytes.
+// It accepts a plain number of bytes, or a number with a suffix
+// such as "KB" (10^3 bytes) or "MiB" (2^20 bytes).
+type byteSize int64
+
+var byteSuffixes = []struct {
+	suffix string
+	n      int64
+}{
+	// Longest suffixes first, so that "MiB" isn't mistaken for "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	// The largest binary suffix that fits, so that 1GiB isn't shown as 1048576KiB.
+	for i := 2; i >= 0; i-- {
+		s := byteSuffixes[i]
+		if *b >= byteSize(s.n) && int64(*b)%s.n == 0 {
+			return fmt.Sprintf("%d%s", int64(*b)/s.n, s.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	mult := int64(1)
+	num := strings.TrimSpace(s)
+	for _, suf := range byteSuffixes {
+		if strings.HasSuffix(num, suf.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, suf.suffix)), suf.n
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
+
+// stringList is a flag.Value for a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// traceMode is a flag.Value for -trace, which may be given alone,
+// to trace requests, or as -trace=body, to trace their bodies too.
+type traceMode string
+
+const (
+	traceOff      traceMode = ""
+	traceRequests traceMode = "requests"
+	traceBodies   traceMode = "body"
+)
+
+func (m *traceMode) String() string { return string(*m) }
+
+func (m *traceMode) Set(s string) error {
+	switch s {
+	case "true", "requests":
+		*m = traceRequests
+	case "false":
+		*m = traceOff
+	case "body":
+		*m = traceBodies
+	default:
+		return fmt.Errorf("invalid trace mode %q; want requests or body", s)
+	}
+	return nil
+}
+
+// IsBoolFlag lets -trace be given without a value.
+func (m *traceMode) IsBoolFlag() bool { return true }
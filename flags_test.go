@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want byteSize
+		str  string // what String returns
+	}{
+		{"0", 0, "0"},
+		{"1500", 1500, "1500"},
+		{"1024", 1024, "1KiB"},
+		{"10B", 10, "10"},
+		{"2KB", 2000, "2000"},
+		{"1KiB", 1 << 10, "1KiB"},
+		{"1.5 MiB", 3 << 19, "1536KiB"},
+		{"4MB", 4e6, "4000000"},
+		{"3GiB", 3 << 30, "3GiB"},
+		{" 2 GB ", 2e9, "1953125KiB"},
+	}
+	for _, tt := range tests {
+		var b byteSize
+		if err := b.Set(tt.s); err != nil {
+			t.Errorf("Set(%q): %v", tt.s, err)
+			continue
+		}
+		if b != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.s, b, tt.want)
+		}
+		if got := b.String(); got != tt.str {
+			t.Errorf("String() of %d = %q, want %q", b, got, tt.str)
+		}
+	}
+	for _, s := range []string{"", "KiB", "-1", "ten", "1TB"} {
+		var b byteSize
+		if err := b.Set(s); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", s, b)
+		}
+	}
+}
+
+func TestTraceMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want traceMode
+	}{
+		{"true", traceRequests},
+		{"requests", traceRequests},
+		{"body", traceBodies},
+		{"false", traceOff},
+	}
+	for _, tt := range tests {
+		var m traceMode
+		if err := m.Set(tt.s); err != nil || m != tt.want {
+			t.Errorf("Set(%q) = %q, %v, want %q", tt.s, m, err, tt.want)
+		}
+	}
+	var m traceMode
+	if err := m.Set("headers"); err == nil {
+		t.Error("Set(\"headers\") succeeded")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// goconstMinLength is the length of the shortest string that GoconstFixer extracts,
+// as for the goconst linter.
+const goconstMinLength = 3
+
+// GoconstFixer extracts string literals that a package repeats at least MinOccurrences times
+// into constants, like the goconst linter suggests, naming each after its value.
+// The constant is declared in the file with the most of the literals,
+// unless the package already has one with that value, which is used instead
+// wherever no local of the same name hides it.
+//
+// Only files that are built on every platform take part, so that the constant is always declared,
+// and only literals that make a good name do: those without format verbs, like %s,
+// whose name isn't a keyword, predeclared or used for anything else in the package.
+// Test files and the literals in const declarations, imports and struct tags are left alone.
+type GoconstFixer struct {
+	MinOccurrences int
+}
+
+func (GoconstFixer) Name() string { return "goconst" }
+
+func (f GoconstFixer) FixPackage(files map[string][]byte) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parsePackages(fset, files)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	for _, pfiles := range pkgs {
+		if err := f.fixPackage(fset, files, pfiles, out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// fixPackage extracts the constants of one package, pfiles, whose sources are in files,
+// adding the new sources of the files it changes to out.
+func (f GoconstFixer) fixPackage(fset *token.FileSet, files map[string][]byte, pfiles []*ast.File, out map[string][]byte) error {
+	names := make(map[string]bool) // every name used in the package
+	existing := make(map[string]string)
+	var lits []*ast.BasicLit
+	litFile := make(map[*ast.BasicLit]*ast.File)
+	for _, file := range pfiles {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				names[id.Name] = true
+			}
+			return true
+		})
+		if !builtEverywhere(fset.File(file.Pos()).Name(), file) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.CONST {
+				for _, spec := range gd.Specs {
+					vs := spec.(*ast.ValueSpec)
+					if vs.Type != nil || len(vs.Names) != 1 || len(vs.Values) != 1 {
+						continue
+					}
+					if v, ok := stringLit(vs.Values[0]); ok && existing[v] == "" {
+						existing[v] = vs.Names[0].Name
+					}
+				}
+			}
+		}
+		tags := make(map[*ast.BasicLit]bool)
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.GenDecl:
+				// Import paths must be literals, and constants are left as they are.
+				return n.Tok != token.CONST && n.Tok != token.IMPORT
+			case *ast.Field:
+				tags[n.Tag] = true
+			case *ast.BasicLit:
+				if n.Kind == token.STRING && !tags[n] {
+					lits = append(lits, n)
+					litFile[n] = file
+				}
+			}
+			return true
+		})
+	}
+
+	byValue := make(map[string][]*ast.BasicLit)
+	var values []string
+	for _, lit := range lits {
+		v, ok := stringLit(lit)
+		if !ok || len(v) < goconstMinLength || strings.ContainsAny(v, "%\n") {
+			continue
+		}
+		if byValue[v] == nil {
+			values = append(values, v)
+		}
+		byValue[v] = append(byValue[v], lit)
+	}
+	sort.Strings(values)
+	// Scopes tell whether an existing constant can be seen where a literal is.
+	info := typeCheckPartial(fset, pfiles)
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	edits := make(map[*ast.File][]edit)
+	decls := make(map[*ast.File][]string)
+	for _, v := range values {
+		vlits := byValue[v]
+		if len(vlits) < f.MinOccurrences {
+			continue
+		}
+		name := existing[v]
+		if name != "" {
+			// A literal where a local of the same name hides the constant
+			// must stay a literal.
+			var visible []*ast.BasicLit
+			for _, lit := range vlits {
+				if !shadowed(info, litFile[lit], name, lit.Pos()) {
+					visible = append(visible, lit)
+				}
+			}
+			vlits = visible
+		} else {
+			name = constName(v)
+			if name == "" || names[name] || token.IsKeyword(name) || types.Universe.Lookup(name) != nil {
+				continue
+			}
+			names[name] = true
+
+			// Declare it in the file with the most of the literals, the first of them if there's a tie.
+			count := make(map[*ast.File]int)
+			var holder *ast.File
+			for _, lit := range vlits {
+				file := litFile[lit]
+				count[file]++
+				if holder == nil || count[file] > count[holder] || count[file] == count[holder] && fset.File(file.Pos()).Name() < fset.File(holder.Pos()).Name() {
+					holder = file
+				}
+			}
+			decls[holder] = append(decls[holder], fmt.Sprintf("%s = %s", name, strconv.Quote(v)))
+		}
+		for _, lit := range vlits {
+			file := litFile[lit]
+			tf := fset.File(file.Pos())
+			edits[file] = append(edits[file], edit{tf.Offset(lit.Pos()), tf.Offset(lit.End()), name})
+		}
+	}
+
+	for _, file := range pfiles {
+		fedits, fdecls := edits[file], decls[file]
+		if len(fedits) == 0 {
+			continue
+		}
+		tf := fset.File(file.Pos())
+		src := files[tf.Name()]
+		if len(fdecls) > 0 {
+			// After the imports, or else the package clause.
+			end := file.Name.End()
+			for _, decl := range file.Decls {
+				if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+					end = gd.End()
+				}
+			}
+			text := "\n\nconst " + fdecls[0]
+			if len(fdecls) > 1 {
+				text = "\n\nconst (\n\t" + strings.Join(fdecls, "\n\t") + "\n)"
+			}
+			fedits = append(fedits, edit{tf.Offset(end), tf.Offset(end), text})
+		}
+		sort.Slice(fedits, func(i, j int) bool { return fedits[i].start < fedits[j].start })
+		var buf bytes.Buffer
+		last := 0
+		for _, e := range fedits {
+			buf.Write(src[last:e.start])
+			buf.WriteString(e.text)
+			last = e.end
+		}
+		buf.Write(src[last:])
+		fixed, err := format.Source(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		out[tf.Name()] = fixed
+	}
+	return nil
+}
+
+// shadowed reports whether, at pos in file, name refers to something other than
+// the package-level object of that name, such as a local variable.
+func shadowed(info *types.Info, file *ast.File, name string, pos token.Pos) bool {
+	fileScope := info.Scopes[file]
+	if fileScope == nil {
+		return true
+	}
+	_, obj := fileScope.Innermost(pos).LookupParent(name, pos)
+	return obj == nil || obj.Parent() != fileScope.Parent()
+}
+
+// stringLit returns the value of e if it is a string literal.
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	v, err := strconv.Unquote(lit.Value)
+	return v, err == nil
+}
+
+// initialisms are the words that Go names spell in capitals, like golint's list.
+var initialisms = map[string]bool{
+	"api": true, "ascii": true, "css": true, "dns": true, "eof": true, "html": true, "http": true, "https": true,
+	"id": true, "ip": true, "json": true, "sql": true, "tcp": true, "tls": true, "uri": true, "url": true,
+	"utf8": true, "uuid": true, "xml": true,
+}
+
+// constName returns a name for a constant with the value v, in lower camel case,
+// such as contentType for "Content-Type" or applicationJSON for "application/json",
+// or "" if v doesn't make a good one.
+func constName(v string) string {
+	words := strings.FieldsFunc(v, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	})
+	if len(words) == 0 || len(words) > 4 || !unicode.IsLetter(rune(words[0][0])) {
+		return ""
+	}
+	for _, r := range v {
+		// The name would leave out any other letters.
+		if r >= unicode.MaxASCII {
+			return ""
+		}
+	}
+	var b strings.Builder
+	for i, w := range words {
+		w = strings.ToLower(w)
+		switch {
+		case i == 0:
+		case initialisms[w]:
+			w = strings.ToUpper(w)
+		default:
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+// goosSuffixes and goarchSuffixes are the GOOS and GOARCH values
+// that a file name can end in, like foo_linux.go, to only be built for them.
+var (
+	goosSuffixes   = strings.Fields("aix android darwin dragonfly freebsd hurd illumos ios js linux nacl netbsd openbsd plan9 solaris wasip1 windows zos")
+	goarchSuffixes = strings.Fields("386 amd64 amd64p32 arm armbe arm64 arm64be loong64 mips mipsle mips64 mips64le mips64p32 mips64p32le ppc ppc64 ppc64le riscv riscv64 s390 s390x sparc sparc64 wasm")
+)
+
+// builtEverywhere reports whether file, the Go file at path p, is built
+// on every platform and not only for tests: whether it isn't a test file,
+// and has neither a build constraint nor a GOOS or GOARCH suffix.
+func builtEverywhere(p string, file *ast.File) bool {
+	name := strings.TrimSuffix(path.Base(p), ".go")
+	if strings.HasSuffix(name, "_test") {
+		return false
+	}
+	elems := strings.Split(name, "_")
+	for _, e := range elems[1:] {
+		for _, s := range append(goosSuffixes, goarchSuffixes...) {
+			if e == s {
+				return false
+			}
+		}
+	}
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package main
+
+import "testing"
+
+func TestGoconstFixer(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string // the files changed
+	}{
+		{
+			name: "new constant",
+			files: map[string]string{
+				"p/a.go": `package p
+
+func f() []string {
+	return []string{"application/json", "application/json", "application/json"}
+}
+`,
+			},
+			want: map[string]string{
+				"p/a.go": `package p
+
+const applicationJSON = "application/json"
+
+func f() []string {
+	return []string{applicationJSON, applicationJSON, applicationJSON}
+}
+`,
+			},
+		},
+		{
+			name: "declared where most literals are",
+			files: map[string]string{
+				"p/a.go": `package p
+
+var a = "content"
+`,
+				"p/b.go": `package p
+
+import "fmt"
+
+func b() {
+	fmt.Println("content", "content")
+}
+`,
+			},
+			want: map[string]string{
+				"p/a.go": `package p
+
+var a = content
+`,
+				"p/b.go": `package p
+
+import "fmt"
+
+const content = "content"
+
+func b() {
+	fmt.Println(content, content)
+}
+`,
+			},
+		},
+		{
+			name: "too few",
+			files: map[string]string{
+				"p/a.go": `package p
+
+var s = []string{"content", "content"}
+`,
+			},
+		},
+		{
+			name: "format verbs",
+			files: map[string]string{
+				"p/a.go": `package p
+
+var s = []string{"got %d", "got %d", "got %d"}
+`,
+			},
+		},
+		{
+			name: "name already taken",
+			files: map[string]string{
+				"p/a.go": `package p
+
+func content() {}
+
+var s = []string{"content", "content", "content"}
+`,
+			},
+		},
+		{
+			name: "existing constant",
+			files: map[string]string{
+				"p/a.go": `package p
+
+const mime = "text/plain"
+
+var s = []string{"text/plain", "text/plain", "text/plain"}
+`,
+			},
+			want: map[string]string{
+				"p/a.go": `package p
+
+const mime = "text/plain"
+
+var s = []string{mime, mime, mime}
+`,
+			},
+		},
+		{
+			name: "existing constant shadowed",
+			files: map[string]string{
+				"p/a.go": `package p
+
+const mime = "text/plain"
+
+func f() []string {
+	mime := "text/html"
+	return []string{mime, "text/plain"}
+}
+
+func g() []string {
+	return []string{"text/plain", "text/plain"}
+}
+`,
+			},
+			want: map[string]string{
+				"p/a.go": `package p
+
+const mime = "text/plain"
+
+func f() []string {
+	mime := "text/html"
+	return []string{mime, "text/plain"}
+}
+
+func g() []string {
+	return []string{mime, mime}
+}
+`,
+			},
+		},
+		{
+			name: "test files and struct tags",
+			files: map[string]string{
+				"p/a.go": `package p
+
+type T struct {
+	A int ` + "`json:\"value\"`" + `
+	B int ` + "`json:\"value\"`" + `
+	C int ` + "`json:\"value\"`" + `
+}
+`,
+				"p/a_test.go": `package p
+
+var s = []string{"content", "content", "content"}
+`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string][]byte)
+			for name, src := range tt.files {
+				files[name] = []byte(src)
+			}
+			got, err := GoconstFixer{MinOccurrences: 3}.FixPackage(files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPackageFix(t, got, tt.want)
+		})
+	}
+}
+
+func TestConstName(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"Content-Type", "contentType"},
+		{"application/json", "applicationJSON"},
+		{"user id", "userID"},
+		{"a b c d e", ""},
+		{"404 not found", ""},
+		{"café", ""},
+		{"--", ""},
+	}
+	for _, tt := range tests {
+		if got := constName(tt.v); got != tt.want {
+			t.Errorf("constName(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+// checkPackageFix reports how the files a PackageFixer changed, got,
+// differ from those it should have, want.
+func checkPackageFix(t *testing.T, got map[string][]byte, want map[string]string) {
+	t.Helper()
+	for name, src := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("changed %s, which should be left alone:\n%s", name, src)
+		}
+	}
+	for name, src := range want {
+		if g, ok := got[name]; !ok {
+			t.Errorf("left %s alone, want:\n%s", name, src)
+		} else if string(g) != src {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", name, g, src)
+		}
+	}
+}
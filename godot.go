@@ -0,0 +1,128 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// GodotFixer ends the doc comments of exported declarations with a period,
+// like the godot linter asks, if they don't already end a sentence.
+// Comments on unexported declarations, and comments within code, are left alone.
+type GodotFixer struct{}
+
+func (GodotFixer) Name() string { return "godot" }
+
+func (f GodotFixer) Fix(filename string, src []byte) ([]byte, error) {
+	out, _, err := f.FixN(filename, src)
+	return out, err
+}
+
+func (GodotFixer) FixN(filename string, src []byte) ([]byte, int, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, 0, err
+	}
+	tf := fset.File(file.Pos())
+
+	var docs []*ast.CommentGroup
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.IsExported() {
+				docs = append(docs, decl.Doc)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				doc := specDoc(spec)
+				if doc == nil && len(decl.Specs) == 1 && !decl.Lparen.IsValid() {
+					doc = decl.Doc
+				}
+				if specExported(spec) {
+					docs = append(docs, doc)
+				}
+			}
+		}
+	}
+
+	var offs []int
+	for _, doc := range docs {
+		if off, ok := missingPeriod(tf, doc); ok {
+			offs = append(offs, off)
+		}
+	}
+	if len(offs) == 0 {
+		return src, 0, nil
+	}
+	sort.Ints(offs)
+	var out []byte
+	last := 0
+	for _, off := range offs {
+		out = append(out, src[last:off]...)
+		out = append(out, '.')
+		last = off
+	}
+	out = append(out, src[last:]...)
+	return out, len(offs), nil
+}
+
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ValueSpec:
+		return spec.Doc
+	}
+	return nil
+}
+
+func specExported(spec ast.Spec) bool {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Name.IsExported()
+	case *ast.ValueSpec:
+		for _, name := range spec.Names {
+			if name.IsExported() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// missingPeriod returns the offset in tf at which a period should go
+// to end the last sentence of doc, reporting whether one is needed.
+// Blank lines and directives such as //go:generate at the end of doc are skipped over,
+// and comments ending in a code block, a URL or a /* */ comment are left alone.
+func missingPeriod(tf *token.File, doc *ast.CommentGroup) (int, bool) {
+	if doc == nil {
+		return 0, false
+	}
+	for i := len(doc.List) - 1; i >= 0; i-- {
+		c := doc.List[i]
+		if !strings.HasPrefix(c.Text, "//") {
+			return 0, false
+		}
+		text := strings.TrimRight(c.Text[2:], " \t\r")
+		if strings.TrimSpace(text) == "" || text[0] != ' ' && text[0] != '\t' {
+			// A blank line, or a directive like //go:generate or //nolint.
+			continue
+		}
+		if strings.HasPrefix(text, "\t") || strings.HasPrefix(text, "  ") {
+			return 0, false
+		}
+		// A period after a URL, like http://example.com, would look like a part of it.
+		if words := strings.Fields(text); strings.Contains(words[len(words)-1], "://") {
+			return 0, false
+		}
+		end := strings.TrimRight(text, ")\"'`")
+		if strings.HasSuffix(end, ".") || strings.HasSuffix(end, "!") || strings.HasSuffix(end, "?") || strings.HasSuffix(end, ":") {
+			return 0, false
+		}
+		return tf.Offset(c.Slash) + 2 + len(text), true
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestGodotFixer(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "exported declarations",
+			src: `package p
+
+// F does things
+func F() {}
+
+// T is a type
+type T int
+
+// V is a variable
+var V int
+`,
+			want: `package p
+
+// F does things.
+func F() {}
+
+// T is a type.
+type T int
+
+// V is a variable.
+var V int
+`,
+		},
+		{
+			name: "unexported declaration",
+			src: `package p
+
+// f does things
+func f() {}
+`,
+		},
+		{
+			name: "sentence already ended",
+			src: `package p
+
+// F does things (really).
+func F() {}
+
+// G asks "why?"
+func G() {}
+
+// H lists:
+func H() {}
+`,
+		},
+		{
+			name: "directive after the comment",
+			src: `package p
+
+// F does things
+//
+//go:noinline
+func F() {}
+`,
+			want: `package p
+
+// F does things.
+//
+//go:noinline
+func F() {}
+`,
+		},
+		{
+			name: "code block",
+			src: `package p
+
+// F does things, like
+//
+//	F()
+func F() {}
+`,
+		},
+		{
+			name: "URL",
+			src: `package p
+
+// F is described at https://example.com/f
+func F() {}
+`,
+		},
+		{
+			name: "block comment",
+			src: `package p
+
+/* F does things */
+func F() {}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.src
+			}
+			got, err := GodotFixer{}.Fix("p.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
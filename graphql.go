@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"github.com/google/go-github/github"
+	"github.com/shurcooL/githubv4"
+)
+
+// graphqlBatch is the most blobs fetched in one GraphQL query,
+// which keeps queries well within GitHub's limits on their size.
+const graphqlBatch = 100
+
+// graphqlMaxFiles is the most files of a repo fetched with GraphQL for -graphql.
+// Repos with more are fetched a file at a time with the REST API as usual,
+// since so many batches save little and risk GitHub's GraphQL rate limit.
+const graphqlMaxFiles = 2000
+
+// blobText is what a GraphQL query asks about each blob.
+type blobText struct {
+	Blob struct {
+		Text        *string
+		IsBinary    bool
+		IsTruncated bool
+	} `graphql:"... on Blob"`
+}
+
+// fetchBlobsGraphQL fetches the contents of files, in github.com/owner/repo,
+// with as few GraphQL queries as it can, returning them keyed by blob SHA.
+// Blobs that are cached aren't fetched, and those that GraphQL can't return intact,
+// such as binary or truncated ones, are left out, to be fetched with the REST API.
+func fetchBlobsGraphQL(ctx context.Context, v4 *githubv4.Client, owner, repo string, files []github.TreeEntry) (map[string][]byte, error) {
+	var shas []string
+	seen := make(map[string]bool)
+	for _, te := range files {
+		if sha := te.GetSHA(); !seen[sha] {
+			seen[sha] = true
+			if _, ok := cache.get(sha); !ok {
+				shas = append(shas, sha)
+			}
+		}
+	}
+
+	blobs := make(map[string][]byte, len(shas))
+	for len(shas) > 0 {
+		batch := shas[:min(len(shas), graphqlBatch)]
+		shas = shas[len(batch):]
+
+		// The number of fields varies, so the query's type is made to fit.
+		// SHAs are hex, so they are safe to put in the query as they are.
+		fields := make([]reflect.StructField, len(batch))
+		for i, sha := range batch {
+			fields[i] = reflect.StructField{
+				Name: fmt.Sprintf("B%d", i),
+				Type: reflect.TypeOf((*blobText)(nil)),
+				Tag:  reflect.StructTag(fmt.Sprintf(`graphql:"b%d: object(oid: \"%s\")"`, i, sha)),
+			}
+		}
+		q := reflect.New(reflect.StructOf([]reflect.StructField{{
+			Name: "Repository",
+			Type: reflect.StructOf(fields),
+			Tag:  `graphql:"repository(owner: $owner, name: $name)"`,
+		}}))
+		err := withRetry(ctx, *maxRetries, func() error {
+			return v4.Query(ctx, q.Interface(), map[string]interface{}{
+				"owner": githubv4.String(owner),
+				"name":  githubv4.String(repo),
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		r := q.Elem().Field(0)
+		for i, sha := range batch {
+			b, _ := r.Field(i).Interface().(*blobText)
+			if b == nil || b.Blob.Text == nil || b.Blob.IsBinary || b.Blob.IsTruncated {
+				continue
+			}
+			// GraphQL returns text, which may not be quite the bytes of the file,
+			// such as if it isn't valid UTF-8, so only blobs that hash right are kept.
+			data := []byte(*b.Blob.Text)
+			if blobSHA(data) != sha {
+				continue
+			}
+			if err := cache.put(sha, data); err != nil {
+				slog.Warn("Caching blob", "sha", sha, "err", err)
+			}
+			blobs[sha] = data
+		}
+	}
+	return blobs, nil
+}
+
+// blobSHA returns the SHA-1 that git names a blob holding data by.
+func blobSHA(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
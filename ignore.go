@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// An ignoreRule is a line of an -ignore-file, which holds patterns like those of .gitignore.
+type ignoreRule struct {
+	pattern []string // slash-separated elements, for matchElems
+	negate  bool     // the pattern started with !, so matching paths are not ignored after all
+	dirOnly bool     // the pattern ended with /, so it only matches directories
+}
+
+type ignoreRules []ignoreRule
+
+// parseIgnore parses the patterns in data, one per line, like those of .gitignore:
+// blank lines and lines starting with # are skipped, ! negates a pattern,
+// a trailing / matches only directories, and a pattern without a slash
+// other than a trailing one matches at any depth rather than only at the root.
+func parseIgnore(data []byte) ignoreRules {
+	var rules ignoreRules
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// \# and \! start patterns with a literal # or !.
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		r.pattern = strings.Split(line, "/")
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// ignored reports whether the file at path p is ignored by rs.
+// As in .gitignore, the last rule that matches decides,
+// and a rule matching a directory matches everything in it.
+func (rs ignoreRules) ignored(p string) bool {
+	elems := strings.Split(p, "/")
+	ignored := false
+	for _, r := range rs {
+		for i := 1; i <= len(elems); i++ {
+			if r.dirOnly && i == len(elems) {
+				break
+			}
+			if matchElems(r.pattern, elems[:i]) {
+				ignored = !r.negate
+				break
+			}
+		}
+	}
+	return ignored
+}
+
+// fetchIgnoreFile returns the rules in the -ignore-file at the root of tree,
+// the tree of github.com/owner/repo, or none if there isn't one.
+func fetchIgnoreFile(ctx context.Context, gh *github.Client, owner, repo string, tree *github.Tree) (ignoreRules, error) {
+	if *ignoreFile == "" {
+		return nil, nil
+	}
+	for _, te := range tree.Entries {
+		if te.GetPath() != *ignoreFile || te.GetType() != "blob" {
+			continue
+		}
+		var data []byte
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			data, err = rawBlob(ctx, gh, rl, owner, repo, te.GetSHA())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return parseIgnore(data), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestIgnore(t *testing.T) {
+	rules := parseIgnore([]byte(`# generated code
+*.pb.go
+!keep.pb.go
+
+/root.go
+gen/
+internal/old/*.go
+\#hash.go
+docs/**/*.go
+`))
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"a.pb.go", true},
+		{"dir/a.pb.go", true},
+		{"keep.pb.go", false},
+		{"dir/keep.pb.go", false},
+		{"root.go", true},
+		{"dir/root.go", false},
+		{"gen/a.go", true},
+		{"dir/gen/a.go", true},
+		{"gen", false},
+		{"internal/old/a.go", true},
+		{"internal/old/sub/a.go", false},
+		{"internal/a.go", false},
+		{"#hash.go", true},
+		{"docs/a.go", true},
+		{"docs/sub/a.go", true},
+		{"a.go", false},
+		{"generated.go", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.path); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreLastRuleWins(t *testing.T) {
+	rules := parseIgnore([]byte("!a.go\n*.go\n"))
+	if !rules.ignored("a.go") {
+		t.Error("a.go is not ignored, though the last rule matching it ignores it")
+	}
+}
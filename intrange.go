@@ -0,0 +1,228 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+)
+
+// IntRangeFixer rewrites loops like for i := 0; i < n; i++
+// to range over the integer as Go 1.22 allows, for i := range n,
+// like the intrange linter suggests, or for range n if the body doesn't use i.
+// It only touches files in Modules whose go directive is at least 1.22.
+//
+// Loops are only rewritten where that can't change what they do:
+// the body mustn't change i, nor n, which range evaluates only once,
+// so n must be a constant, or a local variable or len of one
+// that the function changes nowhere the loop could see.
+type IntRangeFixer struct {
+	Modules []goModule
+}
+
+func (IntRangeFixer) Name() string { return "intrange" }
+
+func (f IntRangeFixer) Fix(filename string, src []byte) ([]byte, error) {
+	out, _, err := f.FixN(filename, src)
+	return out, err
+}
+
+func (f IntRangeFixer) FixN(filename string, src []byte) ([]byte, int, error) {
+	fset := token.NewFileSet()
+	// Object resolution tells local variables from package ones.
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !goVersionAtLeast(filename, file, f.Modules, "go1.22") {
+		return src, 0, nil
+	}
+	tf := fset.File(file.Pos())
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			loop, ok := n.(*ast.ForStmt)
+			if !ok {
+				return true
+			}
+			i, bound, ok := intRangeLoop(fn, loop)
+			if !ok {
+				return true
+			}
+			clause := "range " + string(src[tf.Offset(bound.Pos()):tf.Offset(bound.End())])
+			if uses(loop.Body, i) {
+				clause = i + " := " + clause
+			}
+			edits = append(edits, edit{tf.Offset(loop.Init.Pos()), tf.Offset(loop.Post.End()), clause})
+			return true
+		})
+	}
+	if len(edits) == 0 {
+		return src, 0, nil
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var out []byte
+	last := 0
+	for _, e := range edits {
+		out = append(out, src[last:e.start]...)
+		out = append(out, e.text...)
+		last = e.end
+	}
+	out = append(out, src[last:]...)
+	return out, len(edits), nil
+}
+
+// intRangeLoop reports whether loop, in fn, is like for i := 0; i < n; i++
+// and can range over n instead, and if so returns i and n.
+func intRangeLoop(fn *ast.FuncDecl, loop *ast.ForStmt) (string, ast.Expr, bool) {
+	init, ok := loop.Init.(*ast.AssignStmt)
+	if !ok || init.Tok != token.DEFINE || len(init.Lhs) != 1 || len(init.Rhs) != 1 {
+		return "", nil, false
+	}
+	id, ok := init.Lhs[0].(*ast.Ident)
+	if lit, isLit := init.Rhs[0].(*ast.BasicLit); !ok || id.Name == "_" || !isLit || lit.Value != "0" {
+		return "", nil, false
+	}
+	i := id.Name
+	cond, ok := loop.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.LSS || !isIdent(cond.X, i) {
+		return "", nil, false
+	}
+	post, ok := loop.Post.(*ast.IncDecStmt)
+	if !ok || post.Tok != token.INC || !isIdent(post.X, i) {
+		return "", nil, false
+	}
+	if anyModified(loop.Body, []string{i}) {
+		return "", nil, false
+	}
+	if !fixedBound(fn, loop, cond.Y) {
+		return "", nil, false
+	}
+	return i, cond.Y, true
+}
+
+// fixedBound reports whether bound, the bound of loop in fn,
+// is sure to stay the same while the loop runs.
+func fixedBound(fn *ast.FuncDecl, loop *ast.ForStmt, bound ast.Expr) bool {
+	switch b := bound.(type) {
+	case *ast.BasicLit:
+		return b.Kind == token.INT
+	case *ast.Ident:
+		if b.Obj != nil && b.Obj.Kind == ast.Con {
+			// An untyped float constant like 10.0 compares with ints, but can't be ranged over.
+			if spec, ok := b.Obj.Decl.(*ast.ValueSpec); ok && spec.Type == nil && len(spec.Values) == len(spec.Names) {
+				for j, name := range spec.Names {
+					if lit, ok := spec.Values[j].(*ast.BasicLit); ok && name.Name == b.Name && lit.Kind != token.INT {
+						return false
+					}
+				}
+			}
+			return true
+		}
+		return isLocalVar(fn, b) && !changedDuring(fn, loop, b.Name)
+	case *ast.CallExpr:
+		// The length of a map or channel may change under the loop's feet,
+		// but that of a slice, array or string only if the variable does.
+		fun, ok := b.Fun.(*ast.Ident)
+		if !ok || fun.Name != "len" || fun.Obj != nil || len(b.Args) != 1 {
+			return false
+		}
+		s, ok := b.Args[0].(*ast.Ident)
+		return ok && isLocalVar(fn, s) && isSliceVar(s) && !changedDuring(fn, loop, s.Name)
+	}
+	return false
+}
+
+// isLocalVar reports whether id is a variable, or parameter, declared in fn.
+func isLocalVar(fn *ast.FuncDecl, id *ast.Ident) bool {
+	if id.Obj == nil || id.Obj.Kind != ast.Var {
+		return false
+	}
+	d, ok := id.Obj.Decl.(ast.Node)
+	return ok && d.Pos() >= fn.Pos() && d.End() <= fn.End()
+}
+
+// isSliceVar reports whether the variable id is plainly declared as a slice, array or string,
+// with a type like []T or string, or with a value like []T{...}, make([]T, n) or "...".
+func isSliceVar(id *ast.Ident) bool {
+	isSliceType := func(e ast.Expr) bool {
+		switch t := e.(type) {
+		case *ast.ArrayType, *ast.Ellipsis:
+			return true
+		case *ast.Ident:
+			return t.Name == "string"
+		}
+		return false
+	}
+	switch d := id.Obj.Decl.(type) {
+	case *ast.Field:
+		return isSliceType(d.Type)
+	case *ast.ValueSpec:
+		return d.Type != nil && isSliceType(d.Type)
+	case *ast.AssignStmt:
+		if len(d.Lhs) != len(d.Rhs) {
+			return false
+		}
+		for j, e := range d.Lhs {
+			if !isIdent(e, id.Name) {
+				continue
+			}
+			switch v := d.Rhs[j].(type) {
+			case *ast.CompositeLit:
+				return v.Type != nil && isSliceType(v.Type)
+			case *ast.CallExpr:
+				return isIdent(v.Fun, "make") && len(v.Args) > 0 && isSliceType(v.Args[0])
+			case *ast.BasicLit:
+				return v.Kind == token.STRING
+			}
+		}
+	}
+	return false
+}
+
+// changedDuring reports whether the variable name, local to fn, might change while loop runs:
+// whether the loop's body changes it, a function literal in fn changes it,
+// or fn takes its address.
+func changedDuring(fn *ast.FuncDecl, loop *ast.ForStmt, name string) bool {
+	if anyModified(loop.Body, []string{name}) {
+		return true
+	}
+	changed := false
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			changed = changed || anyModified(n.Body, []string{name})
+		case *ast.UnaryExpr:
+			changed = changed || n.Op == token.AND && isIdent(n.X, name)
+		}
+		return !changed
+	})
+	return changed
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+// uses reports whether anything in body refers to name.
+func uses(body *ast.BlockStmt, name string) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,180 @@
+package main
+
+import "testing"
+
+func TestIntRangeFixer(t *testing.T) {
+	go122 := []goModule{{Root: ".", GoVersion: "1.22"}}
+	tests := []struct {
+		name string
+		mods []goModule
+		src  string
+		want string
+	}{
+		{
+			name: "constant",
+			mods: go122,
+			src: `package p
+
+func f() {
+	for i := 0; i < 10; i++ {
+		println(i)
+	}
+}
+`,
+			want: `package p
+
+func f() {
+	for i := range 10 {
+		println(i)
+	}
+}
+`,
+		},
+		{
+			name: "unused variable",
+			mods: go122,
+			src: `package p
+
+func f(n int) {
+	for i := 0; i < n; i++ {
+		println()
+	}
+}
+`,
+			want: `package p
+
+func f(n int) {
+	for range n {
+		println()
+	}
+}
+`,
+		},
+		{
+			name: "len of a slice",
+			mods: go122,
+			src: `package p
+
+func f(s []int) {
+	for i := 0; i < len(s); i++ {
+		println(s[i])
+	}
+}
+`,
+			want: `package p
+
+func f(s []int) {
+	for i := range len(s) {
+		println(s[i])
+	}
+}
+`,
+		},
+		{
+			name: "body changes i",
+			mods: go122,
+			src: `package p
+
+func f() {
+	for i := 0; i < 10; i++ {
+		i++
+	}
+}
+`,
+		},
+		{
+			name: "body changes n",
+			mods: go122,
+			src: `package p
+
+func f(n int) {
+	for i := 0; i < n; i++ {
+		n--
+	}
+}
+`,
+		},
+		{
+			name: "closure changes n",
+			mods: go122,
+			src: `package p
+
+func f(n int) {
+	shrink := func() { n-- }
+	for i := 0; i < n; i++ {
+		shrink()
+	}
+}
+`,
+		},
+		{
+			name: "package variable",
+			mods: go122,
+			src: `package p
+
+var n = 10
+
+func f() {
+	for i := 0; i < n; i++ {
+		println(i)
+	}
+}
+`,
+		},
+		{
+			name: "float constant",
+			mods: go122,
+			src: `package p
+
+const n = 10.0
+
+func f() {
+	for i := 0; i < n; i++ {
+		println(i)
+	}
+}
+`,
+		},
+		{
+			name: "old module",
+			mods: []goModule{{Root: ".", GoVersion: "1.21"}},
+			src: `package p
+
+func f() {
+	for i := 0; i < 10; i++ {
+		println(i)
+	}
+}
+`,
+		},
+		{
+			name: "old build constraint",
+			mods: go122,
+			src: `//go:build go1.21
+
+package p
+
+func f() {
+	for i := 0; i < 10; i++ {
+		println(i)
+	}
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.src
+			}
+			got, err := IntRangeFixer{Modules: tt.mods}.Fix("p.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
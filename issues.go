@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// issueLabel labels the issues opened with -report-only,
+// which is how prbot finds its open issue in a repo again.
+const issueLabel = "prbot"
+
+// reportIssue opens an issue in owner/repo, for -report-only, listing the files
+// that fixer changes, diffs, and how many lines it changes in each.
+// If prbot already has an issue labeled issueLabel open, it is updated instead.
+func reportIssue(ctx context.Context, gh *github.Client, owner, repo, fixer string, diffs []DiffEntry) (*github.Issue, error) {
+	lg := slog.With("repo", owner+"/"+repo)
+	title := fmt.Sprintf("%d %s need %s", len(diffs), plural(len(diffs), "file", "files"), fixer)
+	var body strings.Builder
+	fmt.Fprintf(&body, "I ran %s over this repository using prbot, an automated tool, and it changes these files:\n\n", fixer)
+	fmt.Fprintf(&body, "| File | Lines changed |\n| --- | --- |\n")
+	for _, d := range diffs {
+		ins, del := d.LineCounts()
+		fmt.Fprintf(&body, "| `%s` | +%d -%d |\n", d.Path, ins, del)
+	}
+	fmt.Fprintf(&body, "\nprbot updates this issue each time it runs while files still need changes.\n")
+	req := &github.IssueRequest{Title: github.String(title), Body: github.String(body.String())}
+
+	// Only prbot's own issue is updated, not others that happen to have the label.
+	// GitHub Apps can't get their user, so for them any issue opened by a bot,
+	// as their issues are, has to do.
+	var me string
+	if *appID == 0 {
+		var err error
+		if me, err = authenticatedLogin(ctx, gh); err != nil {
+			return nil, err
+		}
+	}
+	var open []*github.Issue
+	err := withRetry(ctx, *maxRetries, func() (err error) {
+		open, _, err = gh.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+			State:   "open",
+			Creator: me,
+			Labels:  []string{issueLabel},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing issues: %v", err)
+	}
+	for _, is := range open {
+		// Pull requests are listed as issues too.
+		if is.IsPullRequest() || me == "" && is.GetUser().GetType() != "Bot" {
+			continue
+		}
+		lg.Info("Updating open issue", "url", is.GetHTMLURL())
+		var updated *github.Issue
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			updated, _, err = gh.Issues.Edit(ctx, owner, repo, is.GetNumber(), req)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("updating issue: %v", err)
+		}
+		return updated, nil
+	}
+
+	// The issue must have its label to be found again, whatever -create-missing-labels says.
+	_, _, err = gh.Issues.GetLabel(ctx, owner, repo, issueLabel)
+	if isNotFound(err) {
+		err = createLabel(ctx, gh, owner, repo, issueLabel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var is *github.Issue
+	err = withRetry(ctx, *maxRetries, func() (err error) {
+		is, _, err = gh.Issues.Create(ctx, owner, repo, req)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating issue: %v", err)
+	}
+	if err := addLabels(ctx, gh, owner, repo, is.GetNumber(), append([]string{issueLabel}, labels...)); err != nil {
+		lg.Warn("Adding labels", "url", is.GetHTMLURL(), "err", err)
+	}
+	return is, nil
+}
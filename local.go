@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/go-github/github"
+)
+
+// fixLocal runs fixers, textFixers and pkgFixers over the files under dir,
+// like processRepo does over a repo's tree, rewriting the files that need changes in place.
+// It returns the number of files changed.
+func fixLocal(dir string, fixers, textFixers []Fixer, pkgFixers []PackageFixer) (int, error) {
+	lg := slog.With("dir", dir)
+	var files []github.TreeEntry
+	var mods []goModule
+	perms := make(map[string]fs.FileMode) // keyed by path
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		// Paths are slash-separated and relative to dir, like those in a repo's tree.
+		p := filepath.ToSlash(rel)
+		switch {
+		case d.IsDir():
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			files = append(files, github.TreeEntry{Path: github.String(p), Type: github.String("blob"), Mode: github.String("120000")})
+			return nil
+		case !d.Type().IsRegular():
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if path.Base(p) == "go.mod" {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if m, ok := parseGoMod(p, data); ok {
+				mods = append(mods, m)
+			}
+		}
+		mode := "100644"
+		if info.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		perms[p] = info.Mode().Perm()
+		files = append(files, github.TreeEntry{
+			Path: github.String(p),
+			Type: github.String("blob"),
+			Mode: github.String(mode),
+			Size: github.Int(int(info.Size())),
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var ignore ignoreRules
+	if *ignoreFile != "" {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(*ignoreFile)))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		ignore = parseIgnore(data)
+	}
+	if *intRange {
+		fixers = append([]Fixer{IntRangeFixer{Modules: mods}}, fixers...)
+	}
+	if *copyLoopVar {
+		fixers = append([]Fixer{CopyLoopVarFixer{Modules: mods}}, fixers...)
+	}
+	goFix, textFix := ChainFixers(fixers...), ChainFixers(textFixers...)
+	srcs := make(map[string][]byte) // the Go files, for pkgFixers
+	ins := make(map[string][]byte)  // the files as they were, keyed by path
+	outs := make(map[string][]byte) // the files that need changes, keyed by path
+	for _, te := range files {
+		if !shouldProcess(te) {
+			continue
+		}
+		if skipFile(lg, te, ignore, mods) {
+			continue
+		}
+		in, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(*te.Path)))
+		if err != nil {
+			return 0, err
+		}
+		if *excludeGenerated && isGenerated(in) {
+			lg.Info("Skipping generated file", "path", *te.Path)
+			continue
+		}
+		fix := goFix
+		if !isGoFile(te) {
+			fix = textFix
+		}
+		out, err := fix.Fix(*te.Path, in)
+		if err != nil {
+			lg.Error("Bad Go source", "path", *te.Path, "err", err)
+			continue
+		}
+		ins[*te.Path] = in
+		if len(pkgFixers) > 0 && isGoFile(te) {
+			srcs[*te.Path] = out
+			continue
+		}
+		if !bytes.Equal(in, out) {
+			outs[*te.Path] = out
+		}
+	}
+	if len(pkgFixers) > 0 {
+		for p, out := range fixPackages(lg, srcs, pkgFixers, make([]int64, len(pkgFixers))) {
+			if !bytes.Equal(ins[p], out) {
+				outs[p] = out
+			}
+		}
+	}
+
+	for p, out := range outs {
+		lg.Info("Fixing file", "path", p)
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(p)), out, perms[p]); err != nil {
+			return 0, err
+		}
+	}
+	return len(outs), nil
+}
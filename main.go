@@ -6,23 +6,288 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"go/format"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/google/go-github/github"
-	"golang.org/x/net/context"
+	"github.com/shurcooL/githubv4"
 	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+var (
+	appID             = flag.Int64("app-id", 0, "ID of the GitHub App to authenticate as")
+	applyLocal        = flag.String("apply-local", "", "instead of using the GitHub API, fix the files under `dir`, such as a local clone, in place")
+	appKeyFile        = flag.String("app-private-key-file", "", "file holding the GitHub App's private key, in PEM format")
+	installationID    = flag.Int64("installation-id", 0, "ID of the GitHub App's installation to authenticate as")
+	autoMerge         = flag.Bool("auto-merge", false, "have GitHub merge each pull request once its required checks pass")
+	autoMergeMethod   = flag.String("auto-merge-method", "merge", "with -auto-merge, how to merge: merge, squash or rebase")
+	branch            = flag.String("branch", "master", "branch to scan and make the pull request against")
+	cacheDir          = flag.String("cache-dir", "", "directory to cache fetched files in between runs (default $HOME/.prbot-cache)")
+	cacheMaxAge       = flag.Float64("cache-max-age", 0, "fetch cached files again once they are this many days old (0 means never)")
+	noCache           = flag.Bool("no-cache", false, "don't cache fetched files between runs")
+	checkPRs          = flag.Bool("check-prs", false, "instead of fixing repos, log the state of prbot's pull requests in them")
+	cleanupBranch     = flag.Bool("cleanup-branch", false, "delete the branches of prbot's merged pull requests, before making new ones or with -check-prs")
+	check             = flag.Bool("check", false, "list the files that need changes on stderr instead of making a pull request")
+	noFork            = flag.Bool("no-fork", false, "push the pull request branch to the repo itself instead of to a fork")
+	errWrap           = flag.Bool("errwrap", false, "also change fmt.Errorf calls to wrap their final error argument with %w instead of formatting it with %v")
+	forkOrg           = flag.String("fork-org", "", "organization to make forks in, instead of the authenticated user's account")
+	existingFork      = flag.String("existing-fork", "", "push the pull request branch to this user's existing fork instead of making one")
+	closeStale        = flag.Bool("close-stale-prs", false, "close prbot's open pull requests, and delete their branches, before making new ones")
+	closeStaleComment = flag.String("close-stale-pr-comment", "Closing this in favor of a newer pull request from prbot.", "with -close-stale-prs, comment to leave on the pull requests closed")
+	updatePR          = flag.Bool("update-pr", false, "if prbot already has a pull request open, push the changes to its branch instead of leaving it alone")
+	force             = flag.Bool("force", false, "make a pull request even if prbot already has one open")
+	commitAuthorName  = flag.String("commit-author-name", "", "name of the author and committer of the commit (default the authenticated user)")
+	commitAuthorEmail = flag.String("commit-author-email", "", "email address of the author and committer of the commit")
+	commitMsg         = flag.String("commit-message", "", "message for the commit")
+	commitMsgTmpl     = flag.String("commit-message-template", "", "text/template for the commit message, using {{.FilesChanged}}, {{.Fixer}} and {{.RepoName}}")
+	concurrency       = flag.Int("concurrency", 8, "maximum number of files per repo to fetch and fix at once")
+	createLabels      = flag.Bool("create-missing-labels", false, "create any -label labels that don't exist in the repo")
+	draft             = flag.Bool("draft", false, "make draft pull requests, which not all GitHub plans support for private repos")
+	diffStatOut       = flag.Bool("diff-stat", false, "print a summary of the changes to each repo, like git diff --stat")
+	dryRun            = flag.Bool("dry-run", false, "print a diff of the changes instead of making a pull request")
+	forkWaitTimeout   = flag.Duration("fork-wait-timeout", 60*time.Second, "how long to wait for a new fork to become ready")
+	jsonOut           = flag.Bool("json", false, "write a JSON summary of each repo to stdout")
+	logFilePath       = flag.String("log-file", "", "file to append logs to, in text format, as well as writing them to stderr")
+	logFormat         = flag.String("log-format", "text", "format of log output: text or json")
+	maintainerEdits   = flag.Bool("maintainer-can-modify", true, "let the repo's maintainers push to the pull request branch in the fork")
+	metricsAddr       = flag.String("metrics-addr", "", "address, such as :9090, to serve Prometheus metrics on at /metrics")
+	maxTreeDepth      = flag.Int("max-tree-depth", 20, "for repos with trees too big for GitHub to return at once, the deepest directory to fetch")
+	maxRetries        = flag.Int("max-retries", 3, "number of times to retry GitHub API requests that fail with transient errors")
+	excludeGenerated  = flag.Bool("exclude-generated", true, "skip files marked as generated with a \"// Code generated ... DO NOT EDIT.\" comment")
+	moduleAware       = flag.Bool("module-aware", false, "skip Go files that aren't in a module, with a go.mod file in their directory or above")
+	excludeVendor     = flag.Bool("exclude-vendor", true, "skip files in vendor directories")
+	skipTestFiles     = flag.Bool("skip-test-files", false, "skip Go test files, whose names end in _test.go")
+	fieldAlignment    = flag.Bool("fieldalignment", false, "also reorder the fields of structs that would be smaller in another order")
+	goconst           = flag.Bool("goconst", false, "also extract string literals repeated in a package into constants, like goconst suggests")
+	goconstMin        = flag.Int("goconst-min-occurrences", 3, "with -goconst, how many times a string must appear in a package to be extracted")
+	unconvert         = flag.Bool("unconvert", false, "also remove conversions of values to the type they already have, like unconvert; only covers packages that type-check against the standard library, not those importing other modules")
+	fixWhitespace     = flag.Bool("fix-trailing-whitespace", false, "also strip trailing whitespace from all text files")
+	ignoreFile        = flag.String("ignore-file", ".prbot-ignore", "file at the root of each repo listing gitignore-style patterns for paths not to touch, if it exists")
+	fileExtensions    = flag.String("file-extensions", ".go", "comma-separated extensions of the files to fix as Go source, such as .go,.go.tmpl")
+	fixerName         = flag.String("fixer", "gofmt", "fixer to run over Go source files: gofmt or goimports")
+	fixerCmd          = flag.String("fixer-cmd", "", "also run this command over Go source files, which reads the source on stdin and writes the fixed source to stdout")
+	fixerCmdInPlace   = flag.Bool("fixer-cmd-inplace", false, "pass -fixer-cmd the name of a file to rewrite in place instead of using stdin and stdout")
+	maxFilesChanged   = flag.Int("max-files-changed", 0, "leave alone repos where more than this many files need changes (default no limit)")
+	runMisspell       = flag.Bool("misspell", false, "also correct commonly misspelled English words in Go comments")
+	minFilesChanged   = flag.Int("min-files-changed", 1, "only make a pull request if at least this many files need changes")
+	org               = flag.String("org", "", "process all the repos in this GitHub organization")
+	sinceDays         = flag.Float64("since-days", 0, "skip repos that haven't been pushed to in this many days")
+	sinceTime         = flag.String("since", "", "skip repos that haven't been pushed to since this RFC 3339 time, such as 2023-01-02T15:04:05Z")
+	repoVisibility    = flag.String("repo-visibility", "all", "with -org, which repos to process: all, public or private")
+	search            = flag.String("search", "", "process the repos found by this GitHub repository search, such as 'language:Go pushed:>2023-01-01'")
+	searchLimit       = flag.Int("search-limit", 100, "maximum number of repos to process from -search")
+	topic             = flag.String("topic", "", "process the repos with this GitHub topic, like -search=topic:<topic>")
+	skipForks         = flag.Bool("skip-forks", false, "with -org or -search, skip repos that are forks")
+	skipArchived      = flag.Bool("skip-archived", false, "skip archived repos, which can't take pull requests (default true with -org or -search)")
+	reposFile         = flag.String("repos-file", "", "also process the user/repo repos listed one per line in `file`, or - for stdin")
+	repoInterval      = flag.Duration("repo-interval", 2*time.Second, "minimum time between starting to process each repo")
+	checksAPI         = flag.Bool("github-checks-api", false, "post a "+checkRunName+" check run on the commit fixed instead of making a pull request, failing it if any files need changes; needs -app-id")
+	checkProtection   = flag.Bool("check-branch-protection", false, "warn before making a pull request into a branch whose protection requires approving reviews")
+	reportOnly        = flag.Bool("report-only", false, "open an issue labeled "+issueLabel+" listing the files that need changes instead of making a pull request, or update the one open")
+	useGraphQL        = flag.Bool("graphql", false, "fetch files in bulk with the GraphQL API, falling back to the REST API for those it can't fetch")
+	apiVersion        = flag.String("github-api-version", "", "GitHub REST API version to pin requests to with the X-GitHub-Api-Version header, such as 2022-11-28")
+	githubURL         = flag.String("github-url", "", "GitHub Enterprise API URL, such as https://github.example.com/api/v3/")
+	githubUploadURL   = flag.String("github-upload-url", "", "GitHub Enterprise upload URL (default same as -github-url)")
+	outputPatch       = flag.String("output-patch", "", "write a patch of the changes to `file` instead of making a pull request")
+	outputDir         = flag.String("output-dir", "", "write the changed files under `dir`/owner/repo instead of making a pull request")
+	parallelRepos     = flag.Int("parallel-repos", 1, "number of repos to process concurrently")
+	perFilePRs        = flag.Bool("per-file-pr", false, "make a separate pull request for each changed file")
+	perFilePRLimit    = flag.Int("per-file-pr-limit", 0, "with -per-file-pr, the most pull requests to make in one run (0 means no limit)")
+	prBranchFlag      = flag.String("pr-branch", "prbot-gofmt", "name of the branch to make the pull request from")
+	prBranchTimestamp = flag.Bool("pr-branch-timestamp", false, "append a Unix timestamp to the -pr-branch name and the pull request title")
+	maxPRSize         = flag.Int("max-pr-size", 0, "split the changes to each repo into pull requests of at most this many files, like -max-files-changed with -split-prs")
+	prInterval        = flag.Duration("pr-interval", 2*time.Second, "minimum time between making each of several pull requests for a repo")
+	splitPRs          = flag.Bool("split-prs", false, "with -max-files-changed, make several pull requests of at most that many files instead")
+	assignCodeowners  = flag.Bool("pr-assign-codeowners", false, "also request reviews from the CODEOWNERS of the changed files")
+	linkIssueAction   = flag.String("link-issue-action", "ref", "how the pull request body refers to -link-issue issues: ref, closes or fixes")
+	prNumberOut       = flag.String("pr-number-output", "", "write the number of the pull request made for each repo, or 0 for none, to `file`, one per line")
+	prMilestone       = flag.String("pr-milestone", "", "number or title of the milestone to put pull requests in")
+	createMilestone   = flag.Bool("pr-milestone-create", false, "with -pr-milestone, create the milestone in repos that don't have it")
+	prTitle           = flag.String("pr-title", "", "title of the pull request (default \"<fixer> everything\")")
+	cleanupOnCancel   = flag.Bool("cleanup-on-cancel", false, "when interrupted, delete any fork made for a pull request that is not yet open")
+	prBody            = flag.String("pr-body", "", "body of the pull request")
+	prBodyTemplate    = flag.String("pr-body-template", "", "file holding a text/template for the body of the pull request")
+	prBodyFile        = flag.String("pr-body-file", "", "file holding the body of the pull request")
+	verbose           = flag.Bool("v", false, "log more details")
+	skipIdempotency   = flag.Bool("skip-idempotency-check", false, "don't check that formatting each file a second time leaves it alone")
+	intRange          = flag.Bool("intrange", false, "also rewrite loops like for i := 0; i < n; i++ to range over n, in modules for Go 1.22 or later")
+	copyLoopVar       = flag.Bool("copyloopvar", false, "also remove copies of loop variables, like v := v, from modules for Go 1.22 or later")
+	runGodot          = flag.Bool("godot", false, "also end the doc comments of exported declarations with a period")
+	nakedRet          = flag.Bool("nakedret", false, "also make the naked returns in long functions explicit")
+	nakedRetLength    = flag.Int("nakedret-func-length", 5, "with -nakedret, the longest function, in lines, that may keep its naked returns")
+	simplifyCode      = flag.Bool("simplify", false, "also simplify Go source files like gofmt -s")
+	baseSHA           = flag.String("sha", "", "commit to fix instead of the tip of -branch, which the pull request is still made against")
+	tag               = flag.String("tag", "", "fix the commit this tag points at instead of a branch, making the pull request against the repo's default branch")
+	runStaticcheck    = flag.Bool("staticcheck", false, "also apply fixes suggested by staticcheck's simplification checks")
+	requestsPerSecond = flag.Float64("requests-per-second", 10, "maximum rate of blob fetches from the GitHub API")
+	webhookAddr       = flag.String("webhook-server", "", "instead of processing the given repos, listen on this address, such as :8080, for GitHub push webhooks and process the repos pushed to")
+	webhookSecret     = flag.String("webhook-secret", "", "with -webhook-server, the secret that GitHub signs webhooks with")
+	webhookQueue      = flag.Int("webhook-queue", 100, "with -webhook-server, the most pushed repos to hold waiting to be processed")
+	validateForkPerm  = flag.Bool("validate-fork-permissions", true, "with -no-fork or -existing-fork, check that prbot may push to the repo before committing to it")
+	validateToken     = flag.Bool("validate-token", true, "check at startup that the auth token has the scopes needed to make pull requests")
+	timeout           = flag.Duration("timeout", 0, "give up after this long, such as 10m (0 means never)")
+	repoTimeout       = flag.Duration("per-repo-timeout", 0, "give up on each repo after this long, such as 2m, and go on to the rest (0 means never)")
+	tokenFile         = flag.String("token-file", "", "file holding the GitHub auth token (default $PRBOT_TOKEN or $HOME/.prbot-token)")
+)
+
+var (
+	// assignees are assigned each pull request.
+	assignees stringList
+	// labels are added to each pull request.
+	labels stringList
+	// linkIssues are issues, as owner/repo#N, that each pull request refers to.
+	linkIssues stringList
+	// topicExclude are topics of listed repos to leave alone.
+	topicExclude stringList
+	// trace is what -trace logs of each HTTP request.
+	trace traceMode
+	// maxFileSize is the size of the largest file to fix.
+	maxFileSize = byteSize(1 << 20)
+	// misspellIgnore are misspellings for -misspell not to correct.
+	misspellIgnore stringList
+	// reactions are the emoji reactions to add to each pull request.
+	reactions stringList
+	// rewriteRules are gofmt -r rules to apply, in order.
+	rewriteRules stringList
+	// reviewers are requested to review each pull request.
+	reviewers stringList
+	// skipPaths are glob patterns for paths not to touch.
+	skipPaths stringList
+)
+
+func init() {
+	flag.Var(&assignees, "assignee", "user to assign the pull request to, or @self for prbot's user (may be repeated)")
+	flag.Var(&labels, "label", "label to add to the pull request (may be repeated)")
+	flag.Var(&linkIssues, "link-issue", "issue of the form owner/repo#N to refer to in the pull request body (may be repeated)")
+	flag.Var(&topicExclude, "topic-exclude", "skip repos from -org, -search or -topic that have this GitHub topic, such as prbot-skip (may be repeated)")
+	flag.Var(&trace, "trace", "log each HTTP request made, or with -trace=body their bodies too, up to 4KB of each")
+	flag.Var(&maxFileSize, "max-file-size", "skip files bigger than this, such as 500KB or 2MiB")
+	flag.Var(&misspellIgnore, "misspell-ignore", "misspelled word for -misspell to leave alone (may be repeated)")
+	flag.Var(&reactions, "pr-reaction", "reaction to add to the pull request: +1, -1, laugh, confused, heart, hooray, rocket or eyes (may be repeated)")
+	flag.Var(&rewriteRules, "rewrite", "rewrite rule of the form 'pattern -> replacement' to apply like gofmt -r (may be repeated)")
+	flag.Var(&reviewers, "reviewer", "user or org/team to request a review from (may be repeated)")
+	flag.Var(&skipPaths, "skip-path", "glob pattern, such as 'vendor/**' or '**/*.pb.go', for paths to skip (may be repeated)")
+	flag.StringVar(closeStaleComment, "pr-close-comment", *closeStaleComment, "same as -close-stale-pr-comment")
+}
+
+// A repoResult summarizes what processRepo did to a repo.
+// It is what the -json flag writes out.
+type repoResult struct {
+	Repo         string   `json:"repo"`
+	PRURL        string   `json:"pr_url"`
+	PRNumber     int      `json:"pr_number"`
+	FilesChanged int      `json:"files_changed"`
+	FilesScanned int      `json:"files_scanned"`
+	SkippedFiles []string `json:"skipped_files"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// Options control what processRepo does besides running its fixers.
+type Options struct {
+	// TextFixers are run over files other than Go source files.
+	TextFixers []Fixer
+	// PackageFixers are run over the Go files of each directory together,
+	// after fixers have been run over each file.
+	PackageFixers []PackageFixer
+	// SHA, if set, is the commit to fix instead of the tip of the branch.
+	SHA string
+	// Tag, if set, is the tag whose commit to fix instead of the tip of the branch,
+	// which is then the repo's default branch, whatever processRepo is given.
+	Tag string
+	// MinFilesChanged is the fewest changed files worth a pull request.
+	MinFilesChanged int
+	// MaxFilesChanged, if not zero, is the most changed files allowed in a pull request.
+	// Repos with more are left alone, unless SplitPRs is set.
+	MaxFilesChanged int
+	// SplitPRs has processRepo split the changes into several pull requests
+	// of MaxFilesChanged files each, from branches numbered after -pr-branch.
+	SplitPRs bool
+	// PerFilePRs has processRepo make a pull request for each changed file,
+	// from a branch named after -pr-branch and the file's path.
+	PerFilePRs bool
+	// DiffStat has processRepo print a summary of the changes, like git diff --stat,
+	// before making a pull request.
+	DiffStat bool
+	// ChecksAPI has processRepo post a check run on the commit fixed instead of making a pull request,
+	// annotating each file that needs changes.
+	ChecksAPI bool
+	// ReportOnly has processRepo open an issue listing the files that need changes,
+	// or update the one it has open, instead of making a pull request.
+	ReportOnly bool
+	// DryRun has processRepo print a diff of the changes instead of making a pull request.
+	DryRun bool
+	// Check has processRepo list the files that need changes on stderr instead of making a pull request.
+	Check bool
+	// OutputDir, if set, has processRepo write the changed files under OutputDir/owner/repo
+	// instead of making a pull request.
+	OutputDir string
+	// Patch, if not nil, has processRepo write a unified diff of the changes to it,
+	// in a single call to Write, instead of making a pull request.
+	Patch io.Writer
+	// Result, if not nil, is filled in with what processRepo did.
+	Result *repoResult
+}
+
+// since is the cutoff set by -since or -since-days, if any.
+var since time.Time
+
+// rl paces blob fetches across all repos being processed.
+var rl *rateLimiter
+
+// cache keeps fetched blobs between runs, unless -no-cache is set.
+var cache *blobCache
+
+// v4 is the GraphQL client, which is only set up for -auto-merge and -graphql.
+var v4 *githubv4.Client
+
+// goExts are the -file-extensions of Go source files.
+var goExts = []string{".go"}
+
+// commitTmpl is the parsed -commit-message-template, if any.
+var commitTmpl *template.Template
+
+// prQuota, if not nil, caps the pull requests made by -per-file-pr.
+var prQuota *quota
+
+// rewriters hold the parsed -rewrite rules.
+var rewriters []Fixer
+
+// prBranch is the name of the branch in the fork that pull requests are made from,
+// and titleSuffix is appended to their titles. Both are set by main.
+var prBranch, titleSuffix string
+
+var (
+	// errNoChanges is returned by processRepo for a repo that needs no changes.
+	errNoChanges = errors.New("no changes needed")
+	// errTooManyChanges is returned by processRepo for a repo that needs more changes
+	// than are allowed in a pull request.
+	errTooManyChanges = errors.New("too many files need changes")
+	// errPROpen is returned by processRepo for a repo that already has a prbot pull request open.
+	errPROpen = errors.New("pull request already open")
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: prbot <user/repo>\n")
+	fmt.Fprintf(os.Stderr, "usage: prbot [-org <org>] [-search <query>] [-repos-file <file>] [<user/repo>...]\n")
 	flag.PrintDefaults()
 }
 
@@ -30,165 +295,1720 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	var logFile *os.File
+	if *logFilePath != "" {
+		var err error
+		logFile, err = os.OpenFile(*logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "prbot: opening log file: %v\n", err)
+			os.Exit(1)
+		}
+		// Mark where each run starts, since the file is shared between runs.
+		fmt.Fprintf(logFile, "\n=== prbot started at %s: %s\n", time.Now().Format(time.RFC3339), strings.Join(os.Args[1:], " "))
+	}
+	switch *logFormat {
+	case "text":
+		// The default handler writes through the log package, as prbot always has.
+		slog.SetLogLoggerLevel(level)
+		if logFile != nil {
+			log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+		}
+	case "json":
+		var h slog.Handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+		if logFile != nil {
+			// The log file is always text, to be read by people.
+			h = teeHandler{h, slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: level})}
+		}
+		slog.SetDefault(slog.New(h))
+	default:
 		usage()
 		os.Exit(1)
 	}
-	parts := strings.Split(flag.Arg(0), "/")
-	if len(parts) != 2 {
+
+	args := flag.Args()
+	if *reposFile != "" {
+		more, err := readReposFile(*reposFile)
+		if err != nil {
+			fatal("Reading -repos-file", "err", err)
+		}
+		args = append(args, more...)
+	}
+	switch *repoVisibility {
+	case "all", "public", "private":
+	default:
+		fatal("Bad -repo-visibility; want all, public or private", "visibility", *repoVisibility)
+	}
+	if *topic != "" {
+		// A topic is just another search qualifier.
+		*search = strings.TrimSpace(*search + " topic:" + *topic)
+	}
+	if (len(args) == 0 && *org == "" && *search == "" && *webhookAddr == "" && *applyLocal == "") || *parallelRepos < 1 || *concurrency < 1 {
 		usage()
 		os.Exit(1)
 	}
-	owner, repo := parts[0], parts[1]
+	type ownerRepo struct{ owner, repo string }
+	var repos []ownerRepo
+	for _, arg := range args {
+		parts := strings.Split(arg, "/")
+		if len(parts) != 2 {
+			usage()
+			os.Exit(1)
+		}
+		repos = append(repos, ownerRepo{parts[0], parts[1]})
+	}
+	if _, ok := fixers[*fixerName]; !ok {
+		fatal("Unknown fixer", "fixer", *fixerName)
+	}
+	goExts = nil
+	for _, ext := range strings.Split(*fileExtensions, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			goExts = append(goExts, ext)
+		}
+	}
+	if len(goExts) == 0 {
+		fatal("-file-extensions must list at least one extension")
+	}
+	if *fixerCmd != "" && len(strings.Fields(*fixerCmd)) == 0 {
+		fatal("Empty -fixer-cmd")
+	}
+	if *fixerCmdInPlace && *fixerCmd == "" {
+		fatal("-fixer-cmd-inplace needs -fixer-cmd")
+	}
+	for _, rule := range rewriteRules {
+		rw, err := newRewriteFixer(rule)
+		if err != nil {
+			fatal("Bad -rewrite rule", "rule", rule, "err", err)
+		}
+		rewriters = append(rewriters, rw)
+	}
+	for _, pat := range skipPaths {
+		if _, err := path.Match(pat, ""); err != nil {
+			fatal("Bad -skip-path pattern", "pattern", pat, "err", err)
+		}
+	}
+	for _, r := range reactions {
+		switch r {
+		case "+1", "-1", "laugh", "confused", "heart", "hooray", "rocket", "eyes":
+		default:
+			fatal("Bad -pr-reaction", "reaction", r)
+		}
+	}
+	for _, issue := range linkIssues {
+		if !issueRE.MatchString(issue) {
+			fatal("Bad -link-issue; want owner/repo#N", "issue", issue)
+		}
+	}
+	switch *linkIssueAction {
+	case "ref", "closes", "fixes":
+	default:
+		fatal("Bad -link-issue-action; want ref, closes or fixes", "action", *linkIssueAction)
+	}
 
-	tokenFile := filepath.Join(os.Getenv("HOME"), ".prbot-token")
-	tokenData, err := ioutil.ReadFile(tokenFile)
-	if err != nil {
-		log.Fatalf("Reading auth token: %v", err)
+	switch {
+	case *sinceDays != 0 && *sinceTime != "":
+		fatal("-since and -since-days are mutually exclusive")
+	case *sinceDays < 0:
+		fatal("-since-days must not be negative")
+	case *sinceDays > 0:
+		since = time.Now().Add(-time.Duration(*sinceDays * float64(24*time.Hour)))
+	case *sinceTime != "":
+		var err error
+		since, err = time.Parse(time.RFC3339, *sinceTime)
+		if err != nil {
+			fatal("Bad -since time", "err", err)
+		}
+	}
+	if _, ok := mergeMethods[*autoMergeMethod]; !ok {
+		fatal("Bad -auto-merge-method; want merge, squash or rebase", "method", *autoMergeMethod)
+	}
+	if *autoMerge && *draft {
+		fatal("-auto-merge and -draft are mutually exclusive, since drafts can't be merged")
+	}
+	if *apiVersion != "" {
+		if _, err := time.Parse("2006-01-02", *apiVersion); err != nil {
+			fatal("Bad -github-api-version; want a date like 2022-11-28", "version", *apiVersion)
+		}
+	}
+	if *maxTreeDepth < 0 {
+		fatal("-max-tree-depth must not be negative")
+	}
+	if *cacheMaxAge < 0 {
+		fatal("-cache-max-age must not be negative")
+	}
+	if (*commitAuthorName == "") != (*commitAuthorEmail == "") {
+		fatal("-commit-author-name and -commit-author-email must be given together")
+	}
+	if *existingFork != "" && *noFork {
+		fatal("-existing-fork and -no-fork are mutually exclusive")
+	}
+	if *forkOrg != "" && (*existingFork != "" || *noFork) {
+		fatal("-fork-org can't be used with -existing-fork or -no-fork")
+	}
+	if *maxPRSize > 0 {
+		if isFlagSet("max-files-changed") || isFlagSet("split-prs") {
+			fatal("-max-pr-size stands for -max-files-changed with -split-prs, so can't be used with them")
+		}
+		*maxFilesChanged, *splitPRs = *maxPRSize, true
+	}
+	if *splitPRs && *maxFilesChanged <= 0 {
+		fatal("-split-prs needs -max-files-changed")
+	}
+	if *webhookAddr != "" {
+		switch {
+		case len(args) > 0 || *org != "" || *search != "":
+			fatal("-webhook-server takes its repos from webhooks, so no repos may be given")
+		case *dryRun || *check || *jsonOut || *outputDir != "" || *outputPatch != "" || *baseSHA != "" || *tag != "" || *prNumberOut != "":
+			fatal("-webhook-server only makes pull requests, so it can't be used with -dry-run, -check, -json, -output-dir, -output-patch, -pr-number-output, -sha or -tag")
+		case *webhookSecret == "":
+			fatal("-webhook-server needs -webhook-secret, so that only GitHub can trigger it")
+		case *webhookQueue < 1:
+			fatal("-webhook-queue must be at least 1")
+		}
+	}
+	if *goconst && *goconstMin < 2 {
+		fatal("-goconst-min-occurrences must be at least 2")
+	}
+	if *updatePR && *closeStale {
+		fatal("-update-pr and -close-stale-prs are mutually exclusive")
+	}
+	if *updatePR && *force {
+		fatal("-update-pr and -force are mutually exclusive")
+	}
+	if *perFilePRs && *splitPRs {
+		fatal("-per-file-pr and -split-prs are mutually exclusive")
+	}
+	if *perFilePRLimit > 0 {
+		prQuota = newQuota(*perFilePRLimit)
+	}
+	if *tag != "" && (isFlagSet("branch") || *baseSHA != "") {
+		fatal("-tag can't be used with -branch or -sha, since it says what to fix itself")
+	}
+	if *baseSHA != "" && (len(args) > 1 || *org != "" || *search != "") {
+		fatal("-sha only makes sense with a single repo")
+	}
+	if *jsonOut && *dryRun {
+		fatal("-json and -dry-run both write to stdout, so are mutually exclusive")
+	}
+	if *applyLocal != "" {
+		switch {
+		case len(args) > 0 || *org != "" || *search != "" || *webhookAddr != "":
+			fatal("-apply-local fixes the files in its directory, so no repos may be given")
+		case *dryRun || *check || *jsonOut || *outputDir != "" || *outputPatch != "" || *checksAPI || *reportOnly:
+			fatal("-apply-local can't be used with -dry-run, -check, -json, -output-dir, -output-patch, -github-checks-api or -report-only")
+		}
+	}
+	if *checkPRs {
+		switch {
+		case *webhookAddr != "" || *applyLocal != "":
+			fatal("-check-prs can't be used with -webhook-server or -apply-local")
+		case *dryRun || *check || *jsonOut || *outputDir != "" || *outputPatch != "" || *checksAPI || *reportOnly:
+			fatal("-check-prs can't be used with -dry-run, -check, -json, -output-dir, -output-patch, -github-checks-api or -report-only")
+		}
+	}
+	if *checksAPI {
+		switch {
+		case *appID == 0:
+			fatal("-github-checks-api needs -app-id, since only GitHub Apps can post check runs")
+		case *dryRun || *check || *outputDir != "" || *outputPatch != "":
+			fatal("-github-checks-api can't be used with -dry-run, -check, -output-dir or -output-patch")
+		}
+	}
+	if *reportOnly && (*dryRun || *check || *outputDir != "" || *outputPatch != "" || *checksAPI) {
+		fatal("-report-only can't be used with -dry-run, -check, -output-dir, -output-patch or -github-checks-api")
+	}
+	if *jsonOut && *diffStatOut {
+		fatal("-json and -diff-stat both write to stdout, so are mutually exclusive")
 	}
-	ts := oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: string(tokenData),
-	})
-	tc := oauth2.NewClient(context.Background(), ts)
-	gh := github.NewClient(tc)
-	gh.UserAgent = "prbot/0.1"
 
-	const branch = "master" // TODO: flag for this
+	if *commitMsgTmpl != "" {
+		if *commitMsg != "" {
+			fatal("-commit-message and -commit-message-template are mutually exclusive")
+		}
+		var err error
+		commitTmpl, err = template.New("commit").Parse(*commitMsgTmpl)
+		if err != nil {
+			fatal("Bad -commit-message-template", "err", err)
+		}
+	}
 
-	log.Printf("Resolving branch %s in github.com/%s/%s ...", branch, owner, repo)
-	ref, _, err := gh.Git.GetRef(owner, repo, "refs/heads/"+branch)
-	if err != nil {
-		log.Fatalf("Getting ref: %v", err)
+	if *prBodyFile != "" {
+		if *prBody != "" {
+			fatal("-pr-body and -pr-body-file are mutually exclusive")
+		}
+		data, err := ioutil.ReadFile(*prBodyFile)
+		if err != nil {
+			fatal("Reading pull request body", "err", err)
+		}
+		*prBody = string(data)
+	}
+	if *prBodyTemplate != "" {
+		if *prBody != "" {
+			fatal("-pr-body-template can't be used with -pr-body or -pr-body-file")
+		}
+		data, err := ioutil.ReadFile(*prBodyTemplate)
+		if err != nil {
+			fatal("Reading pull request body template", "err", err)
+		}
+		prBodyTmpl, err = template.New("body").Parse(string(data))
+		if err != nil {
+			fatal("Bad -pr-body-template", "err", err)
+		}
 	}
-	if *ref.Object.Type != "commit" {
-		log.Fatalf("branch %s does not point at a commit", branch)
+
+	prBranch = *prBranchFlag
+	if *prBranchTimestamp {
+		now := time.Now().Unix()
+		prBranch = fmt.Sprintf("%s-%d", prBranch, now)
+		titleSuffix = fmt.Sprintf(" %d", now)
 	}
-	origCommit := *ref.Object.SHA
 
-	log.Printf("Fetching tree for github.com/%s/%s @ %s ...", owner, repo, origCommit)
-	tree, _, err := gh.Git.GetTree(owner, repo, origCommit, true /* recursive */)
+	// Rewrites go first, so that the fixers tidy up after them.
+	fs := append([]Fixer(nil), rewriters...)
+	format := func(f Fixer) Fixer {
+		if *skipIdempotency {
+			return f
+		}
+		return checkIdempotent(f)
+	}
+	if *simplifyCode {
+		fs = append(fs, format(SimplifyFixer{}))
+	}
+	// gofmt -s formats as well as simplifying, so it can stand in for gofmt.
+	if !*simplifyCode || *fixerName != "gofmt" {
+		fs = append(fs, format(fixers[*fixerName]))
+	}
+	// misspell only touches comments, and staticcheck's fixes only code,
+	// so they can run side by side.
+	var independent []Fixer
+	if *runMisspell {
+		independent = append(independent, newMisspellFixer(misspellIgnore))
+	}
+	if *runStaticcheck {
+		independent = append(independent, StaticcheckFixer{})
+	}
+	switch len(independent) {
+	case 1:
+		fs = append(fs, independent[0])
+	case 2:
+		fs = append(fs, ParallelFixer(independent...))
+	}
+	if *runGodot {
+		fs = append(fs, GodotFixer{})
+	}
+	if *nakedRet {
+		fs = append(fs, NakedReturnFixer{MaxLength: *nakedRetLength})
+	}
+	var pkgFixers []PackageFixer
+	if *fieldAlignment {
+		pkgFixers = append(pkgFixers, FieldAlignmentFixer{})
+	}
+	if *errWrap {
+		pkgFixers = append(pkgFixers, ErrWrapFixer{})
+	}
+	if *unconvert {
+		pkgFixers = append(pkgFixers, UnconvertFixer{})
+	}
+	if *goconst {
+		pkgFixers = append(pkgFixers, GoconstFixer{MinOccurrences: *goconstMin})
+	}
+	// Go files are left to the Go fixers, which know not to touch raw strings.
+	var textFixers []Fixer
+	if *fixWhitespace {
+		textFixers = append(textFixers, TrailingWhitespaceFixer{})
+	}
+
+	if *applyLocal != "" {
+		if *fixerCmd != "" {
+			fs = append(fs, CmdFixer{Ctx: context.Background(), Args: strings.Fields(*fixerCmd), InPlace: *fixerCmdInPlace})
+		}
+		n, err := fixLocal(*applyLocal, fs, textFixers, pkgFixers)
+		if err != nil {
+			fatal("Fixing local files", "dir", *applyLocal, "err", err)
+		}
+		slog.Info("Fixed local files", "dir", *applyLocal, "files", n)
+		return
+	}
+
+	ts, err := tokenSource()
 	if err != nil {
-		log.Fatalf("Getting tree: %v", err)
+		fatal("Reading auth token", "err", err)
 	}
-	log.Printf("Original tree with %d entries: %s ...", len(tree.Entries), *tree.SHA)
-	var goFiles []github.TreeEntry
-	for _, te := range tree.Entries {
-		if *te.Type == "blob" && strings.HasSuffix(*te.Path, ".go") {
-			// Safety measure; let's stick with files under 1 MB.
-			if te.Size != nil && *te.Size > 1<<20 {
-				log.Printf("Warning: Skipping %s because it is too big", *te.Path)
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCtx.Done()
+		slog.Warn("Interrupted; stopping after the repos in progress (interrupt again to quit now)")
+		stop() // so that another interrupt kills prbot
+	}()
+	ctx := sigCtx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	tc := oauth2.NewClient(ctx, ts)
+	tc.Transport = countingTransport{tc.Transport}
+	if *apiVersion != "" {
+		tc.Transport = apiVersionTransport{tc.Transport, *apiVersion}
+	}
+	if trace != traceOff {
+		tc.Transport = traceTransport{tc.Transport, trace == traceBodies}
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+	gh, err := newClient(tc)
+	if err != nil {
+		fatal("Creating GitHub client", "err", err)
+	}
+
+	if *autoMerge || *useGraphQL {
+		v4 = newGraphQLClient(tc)
+	}
+	// GitHub Apps have permissions rather than scopes, and can't get their user.
+	readOnly := *dryRun || *check || *outputDir != "" || *outputPatch != ""
+	if *validateToken && *appID == 0 && !readOnly {
+		if err := checkScopes(ctx, gh); err != nil {
+			fatal("Checking auth token", "err", err)
+		}
+	}
+
+	rl = newRateLimiter(*requestsPerSecond)
+	if !*noCache {
+		dir := *cacheDir
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				fatal("No home directory for the blob cache; pass -cache-dir or -no-cache", "err", err)
+			}
+			dir = filepath.Join(home, ".prbot-cache")
+		}
+		cache = &blobCache{dir: dir, maxAge: time.Duration(*cacheMaxAge * float64(24*time.Hour))}
+	}
+
+	if *webhookAddr != "" {
+		opts := Options{
+			TextFixers:      textFixers,
+			PackageFixers:   pkgFixers,
+			MinFilesChanged: *minFilesChanged,
+			MaxFilesChanged: *maxFilesChanged,
+			SplitPRs:        *splitPRs,
+			PerFilePRs:      *perFilePRs,
+			ChecksAPI:       *checksAPI,
+			ReportOnly:      *reportOnly,
+		}
+		srv := newWebhookServer([]byte(*webhookSecret), *webhookQueue, func(ctx context.Context, owner, repo, branch string) {
+			o := opts
+			o.Result = new(repoResult)
+			repoCtx, cancel := repoContext(ctx)
+			defer cancel()
+			done := startRepo()
+			pr, err := processRepo(repoCtx, gh, owner, repo, branch, fs, o)
+			done(o.Result, pr)
+			switch {
+			case err == errNoChanges || err == errPROpen || err == errTooManyChanges:
+				// processRepo has said why.
+			case err != nil && ctx.Err() == nil && repoCtx.Err() != nil:
+				slog.Error("Processing repo timed out", "repo", owner+"/"+repo, "timeout", *repoTimeout, "err", err)
+			case err != nil:
+				slog.Error("Processing repo failed", "repo", owner+"/"+repo, "err", err)
+			case pr != nil:
+				slog.Info("Made pull request for push", "repo", owner+"/"+repo, "url", pr.GetHTMLURL())
+			}
+		})
+		if err := srv.serve(ctx, *webhookAddr, *parallelRepos); err != nil {
+			fatal("Serving webhooks", "err", err)
+		}
+		return
+	}
+
+	// Archived repos are read-only, so there's no point trying them,
+	// nor repos that haven't been pushed to since the -since cutoff.
+	// It takes an API request to tell for repos not listed from an org or a search.
+	if (*org != "" || *search != "") && !isFlagSet("skip-archived") {
+		*skipArchived = true
+	}
+	var archived, inactive int
+	if *skipArchived || !since.IsZero() {
+		kept := repos[:0]
+		for _, r := range repos {
+			var info *github.Repository
+			err := withRetry(ctx, *maxRetries, func() (err error) {
+				info, _, err = gh.Repositories.Get(ctx, r.owner, r.repo)
+				return err
+			})
+			switch {
+			case err != nil:
+				// processRepo will say why.
+			case *skipArchived && info.GetArchived():
+				slog.Info("Skipping archived repo", "repo", r.owner+"/"+r.repo)
+				archived++
+				continue
+			case info.GetPushedAt().Time.Before(since):
+				slog.Info("Skipping inactive repo", "repo", r.owner+"/"+r.repo, "pushed_at", info.GetPushedAt().Time)
+				inactive++
+				continue
+			}
+			kept = append(kept, r)
+		}
+		repos = kept
+	}
+
+	var listed []*github.Repository
+	if *org != "" {
+		slog.Info("Listing repos", "org", *org)
+		orgRepos, err := listOrgRepos(ctx, gh, *org, *repoVisibility)
+		if err != nil {
+			fatal("Listing repos", "org", *org, "err", err)
+		}
+		slog.Info("Found repos", "org", *org, "repos", len(orgRepos))
+		listed = append(listed, orgRepos...)
+	}
+	if *search != "" {
+		slog.Info("Searching for repos", "query", *search)
+		found, err := searchRepos(ctx, gh, *search, *searchLimit)
+		if err != nil {
+			fatal("Searching for repos", "query", *search, "err", err)
+		}
+		slog.Info("Found repos", "query", *search, "repos", len(found))
+		listed = append(listed, found...)
+	}
+	if len(listed) > 0 {
+		var forks, listedArchived, listedInactive, excluded int
+		for _, r := range listed {
+			switch {
+			case *skipForks && r.GetFork():
+				forks++
+			case hasTopic(r, topicExclude):
+				excluded++
+			case *skipArchived && r.GetArchived():
+				listedArchived++
+			case r.GetPushedAt().Time.Before(since):
+				listedInactive++
+			default:
+				repos = append(repos, ownerRepo{r.GetOwner().GetLogin(), r.GetName()})
+			}
+		}
+		slog.Info("Filtered listed repos", "repos", len(listed), "skipped_forks", forks, "skipped_archived", listedArchived, "skipped_inactive", listedInactive, "skipped_by_topic", excluded)
+		archived += listedArchived
+		inactive += listedInactive
+	}
+
+	if *checkPRs {
+		var failed int
+		for _, r := range repos {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := reportPRs(ctx, gh, r.owner, r.repo); err != nil {
+				slog.Error("Checking pull requests failed", "repo", r.owner+"/"+r.repo, "err", err)
+				failed++
+			}
+		}
+		if failed > 0 || ctx.Err() != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var patch io.Writer
+	var patchFile *os.File
+	if *outputPatch != "" {
+		patchFile, err = os.Create(*outputPatch)
+		if err != nil {
+			fatal("Creating patch file", "err", err)
+		}
+		patch = &syncWriter{w: patchFile}
+	}
+
+	// Space out the repos, so as not to trip GitHub's abuse detection.
+	repoLimiter := rate.NewLimiter(rate.Every(*repoInterval), 1)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var changed, clean, open, tooBig, failed int
+	results := make([]repoResult, len(repos))
+	sem := make(chan struct{}, *parallelRepos)
+	started := 0
+	for i, r := range repos {
+		i, r := i, r
+		sem <- struct{}{}
+		if err := repoLimiter.Wait(ctx); err != nil {
+			break
+		}
+		started++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := &results[i]
+			res.Repo = r.owner + "/" + r.repo
+			res.SkippedFiles = []string{} // so that it's never null in JSON
+			repoCtx, cancel := repoContext(ctx)
+			defer cancel()
+			done := startRepo()
+			pr, err := processRepo(repoCtx, gh, r.owner, r.repo, *branch, fs, Options{
+				TextFixers:      textFixers,
+				PackageFixers:   pkgFixers,
+				SHA:             *baseSHA,
+				Tag:             *tag,
+				MinFilesChanged: *minFilesChanged,
+				MaxFilesChanged: *maxFilesChanged,
+				SplitPRs:        *splitPRs,
+				PerFilePRs:      *perFilePRs,
+				DiffStat:        *diffStatOut,
+				ChecksAPI:       *checksAPI,
+				ReportOnly:      *reportOnly,
+				DryRun:          *dryRun,
+				Check:           *check,
+				OutputDir:       *outputDir,
+				Patch:           patch,
+				Result:          res,
+			})
+			done(res, pr)
+			if pr != nil {
+				res.PRURL = pr.GetHTMLURL()
+				res.PRNumber = pr.GetNumber()
+			}
+			if err != nil && err != errNoChanges && err != errPROpen {
+				res.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == errNoChanges:
+				clean++
+			case err == errPROpen:
+				open++
+			case err == errTooManyChanges:
+				tooBig++
+			case err != nil && ctx.Err() != nil:
+				slog.Warn("Interrupted while processing repo", "repo", res.Repo, "err", err)
+				failed++
+			case err != nil && repoCtx.Err() != nil:
+				slog.Error("Processing repo timed out", "repo", res.Repo, "timeout", *repoTimeout, "err", err)
+				failed++
+			case err != nil:
+				slog.Error("Processing repo failed", "repo", res.Repo, "err", err)
+				failed++
+			default:
+				changed++
+			}
+		}()
+	}
+	wg.Wait()
+	results = results[:started]
+	// The repos in progress have logged what they were doing.
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		slog.Error("Timed out", "timeout", *timeout, "repos_not_started", len(repos)-started)
+	}
+	if patchFile != nil {
+		if err := patchFile.Close(); err != nil {
+			fatal("Writing patch file", "err", err)
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		for _, res := range results {
+			if err := enc.Encode(res); err != nil {
+				fatal("Writing JSON", "err", err)
+			}
+		}
+	}
+
+	if *prNumberOut != "" {
+		var buf bytes.Buffer
+		for _, res := range results {
+			fmt.Fprintln(&buf, res.PRNumber)
+		}
+		if len(results) == 0 {
+			buf.WriteString("0\n")
+		}
+		if err := ioutil.WriteFile(*prNumberOut, buf.Bytes(), 0666); err != nil {
+			fatal("Writing pull request numbers", "err", err)
+		}
+	}
+
+	if len(repos)+archived+inactive > 1 {
+		key := "prs_created"
+		if *dryRun || *check || *outputDir != "" || *outputPatch != "" || *checksAPI || *reportOnly {
+			key = "need_changes"
+		}
+		slog.Info("Summary", key, changed, "clean", clean, "already_open", open, "too_many_changes", tooBig, "skipped_archived", archived, "skipped_inactive", inactive, "failed", failed)
+	}
+	// In dry-run and check modes, exit non-zero like diff(1) so that CI can notice.
+	if failed > 0 || timedOut || ((*dryRun || *check) && changed > 0) {
+		os.Exit(1)
+	}
+}
+
+// processRepo runs fixers in turn over the Go files on branch of github.com/owner/repo,
+// and makes a pull request against branch with the changes, which it returns.
+// It returns errNoChanges if the repo is already clean,
+// and a nil pull request with opts.DryRun, opts.Check, opts.OutputDir or opts.Patch.
+func processRepo(ctx context.Context, gh *github.Client, owner, repo, branch string, fixers []Fixer, opts Options) (*github.PullRequest, error) {
+	lg := slog.With("repo", owner+"/"+repo)
+	res := opts.Result
+	if res == nil {
+		res = new(repoResult)
+	}
+	// Which files some fixers may change depends on the Go versions in the repo's go.mod files,
+	// which are read into these once the tree has been fetched.
+	var needMods []*[]goModule
+	if *intRange {
+		f := new(IntRangeFixer)
+		needMods = append(needMods, &f.Modules)
+		fixers = append([]Fixer{f}, fixers...)
+	}
+	if *copyLoopVar {
+		f := new(CopyLoopVarFixer)
+		needMods = append(needMods, &f.Modules)
+		fixers = append([]Fixer{f}, fixers...)
+	}
+	// The fixer command, which runs last, is killed along with the repo's context.
+	if *fixerCmd != "" {
+		fixers = append(fixers[:len(fixers):len(fixers)], CmdFixer{Ctx: ctx, Args: strings.Fields(*fixerCmd), InPlace: *fixerCmdInPlace})
+	}
+	var names []string
+	n := len(opts.TextFixers) + len(opts.PackageFixers)
+	for _, f := range fixers {
+		if p, ok := f.(parallel); ok {
+			n += len(p)
+		} else {
+			n++
+		}
+	}
+	counts := make([]int64, n)
+	fixes := make([]int64, len(counts))
+	// count wraps f, counting the changes it makes.
+	count := func(f Fixer) Fixer {
+		names = append(names, f.Name())
+		return countChanges(f, &counts[len(names)-1], &fixes[len(names)-1])
+	}
+	// wrap chains fs, counting the changes each one makes. It overwrites fs.
+	wrap := func(fs []Fixer) Fixer {
+		for i, f := range fs {
+			if p, ok := f.(parallel); ok {
+				// Count each of the fixers run at once separately.
+				q := make([]Fixer, len(p))
+				for j, f := range p {
+					q[j] = count(f)
+				}
+				fs[i] = ParallelFixer(q...)
 				continue
 			}
-			goFiles = append(goFiles, te)
+			fs[i] = count(f)
+		}
+		return ChainFixers(fs...)
+	}
+	goFix, textFix := wrap(append([]Fixer(nil), fixers...)), wrap(append([]Fixer(nil), opts.TextFixers...))
+	pkgCounts := counts[len(names):]
+	for _, pf := range opts.PackageFixers {
+		names = append(names, pf.Name())
+	}
+	fixerDesc := strings.Join(names, " and ")
+
+	origCommit := opts.SHA
+	if opts.Tag != "" {
+		lg.Info("Resolving tag", "tag", opts.Tag)
+		var err error
+		if origCommit, err = resolveTag(ctx, gh, owner, repo, opts.Tag); err != nil {
+			return nil, err
+		}
+		var r *github.Repository
+		err = withRetry(ctx, *maxRetries, func() (err error) {
+			r, _, err = gh.Repositories.Get(ctx, owner, repo)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting repo: %v", err)
 		}
+		branch = r.GetDefaultBranch()
+	}
+	if origCommit == "" {
+		lg.Info("Resolving branch", "branch", branch)
+		var ref *github.Reference
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			ref, _, err = gh.Git.GetRef(ctx, owner, repo, "refs/heads/"+branch)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting ref: %v", err)
+		}
+		if *ref.Object.Type != "commit" {
+			return nil, fmt.Errorf("branch %s does not point at a commit", branch)
+		}
+		origCommit = *ref.Object.SHA
 	}
-	log.Printf("Found %d Go source files", len(goFiles))
 
-	// TODO: sensible rate limiting...
+	lg.Info("Fetching tree", "sha", origCommit)
+	tree, err := fetchTree(ctx, gh, owner, repo, origCommit)
+	if err != nil {
+		return nil, fmt.Errorf("getting tree: %v", err)
+	}
+	lg.Info("Fetched original tree", "sha", *tree.SHA, "entries", len(tree.Entries))
+	ignore, err := fetchIgnoreFile(ctx, gh, owner, repo, tree)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", *ignoreFile, err)
+	}
+	var mods []goModule
+	if *moduleAware || len(needMods) > 0 {
+		mods, err = goModules(ctx, gh, owner, repo, tree)
+		if err != nil {
+			return nil, fmt.Errorf("finding modules: %v", err)
+		}
+		roots := make([]string, len(mods))
+		for i, m := range mods {
+			roots[i] = m.Root
+		}
+		lg.Info("Found modules", "roots", roots)
+		for _, m := range needMods {
+			*m = mods
+		}
+	}
+	var files []github.TreeEntry
+	for _, te := range tree.Entries {
+		if !shouldProcess(te) {
+			continue
+		}
+		if skipFile(lg, te, ignore, mods) {
+			res.SkippedFiles = append(res.SkippedFiles, *te.Path)
+			continue
+		}
+		files = append(files, te)
+	}
+	lg.Info("Found files to fix", "files", len(files))
+	res.FilesScanned = len(files)
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var changes []github.TreeEntry
-	add := func(base github.TreeEntry, newContents string) {
+	var diffs []DiffEntry // in the same order as changes
+	skip := func(te github.TreeEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		res.SkippedFiles = append(res.SkippedFiles, *te.Path)
+	}
+	add := func(base github.TreeEntry, in, out []byte) {
 		mu.Lock()
 		defer mu.Unlock()
 		changes = append(changes, github.TreeEntry{
 			Path:    base.Path,
 			Mode:    base.Mode,
 			Type:    base.Type,
-			Content: github.String(newContents),
+			Content: github.String(string(out)),
 		})
+		diffs = append(diffs, DiffEntry{
+			Path:        *base.Path,
+			OriginalSHA: *base.SHA,
+			Original:    in,
+			Fixed:       out,
+		})
+	}
+	// With package fixers, the Go files are kept until those have been run, changed or not.
+	type goFile struct {
+		te      github.TreeEntry
+		in, out []byte
+	}
+	goFiles := make(map[string]goFile) // keyed by path
+	keep := func(te github.TreeEntry, in, out []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		goFiles[*te.Path] = goFile{te, in, out}
 	}
-	for _, te := range goFiles {
+	var prefetched map[string][]byte // keyed by blob SHA
+	if *useGraphQL && len(files) > graphqlMaxFiles {
+		lg.Info("Too many files to fetch with GraphQL; fetching them one at a time", "files", len(files), "max", graphqlMaxFiles)
+	} else if *useGraphQL {
+		lg.Info("Fetching files with GraphQL", "files", len(files))
+		prefetched, err = fetchBlobsGraphQL(ctx, v4, owner, repo, files)
+		if err != nil {
+			lg.Warn("Fetching files with GraphQL failed; fetching them one at a time", "err", err)
+		}
+	}
+	sem := make(chan struct{}, *concurrency)
+	for _, te := range files {
 		te := te
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			abbr := fmt.Sprintf("%s %.7s", *te.Path, *te.SHA)
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-			in, err := rawBlob(gh, owner, repo, *te.SHA)
+			in, ok := prefetched[*te.SHA]
+			var err error
+			if !ok {
+				err = withRetry(ctx, *maxRetries, func() (err error) {
+					in, err = rawBlob(ctx, gh, rl, owner, repo, *te.SHA)
+					return err
+				})
+			}
 			if err != nil {
-				log.Printf("Fetching blob (%s): %v", abbr, err)
+				lg.Error("Fetching blob", "path", *te.Path, "sha", *te.SHA, "err", err)
+				skip(te)
+				return
+			}
+			if *excludeGenerated && isGenerated(in) {
+				// It would only be regenerated as it was.
+				lg.Info("Skipping generated file", "path", *te.Path)
+				skip(te)
 				return
 			}
-			out, err := format.Source(in)
+			fix := goFix
+			if !isGoFile(te) {
+				fix = textFix
+			}
+			out, err := fix.Fix(*te.Path, in)
 			if err != nil {
-				log.Printf("Bad Go source (%s): %v", abbr, err)
-				log.Printf("%s\n", in)
+				lg.Error("Bad Go source", "path", *te.Path, "sha", *te.SHA, "err", err)
+				lg.Debug("Bad Go source", "path", *te.Path, "sha", *te.SHA, "src", string(in))
+				skip(te)
+				return
+			}
+			if len(opts.PackageFixers) > 0 && isGoFile(te) {
+				keep(te, in, out)
 				return
 			}
 			if bytes.Equal(in, out) {
 				return
 			}
-			log.Printf("(%s) needs gofmt'ing!", abbr)
-			add(te, string(out))
+			lg.Info("File needs changes", "path", *te.Path, "sha", *te.SHA, "fixer", fixerDesc)
+			add(te, in, out)
 		}()
 	}
 	wg.Wait()
-	log.Printf("Found %d Go source files that need changes", len(changes))
+
+	if len(opts.PackageFixers) > 0 {
+		srcs := make(map[string][]byte, len(goFiles))
+		for p, f := range goFiles {
+			srcs[p] = f.out
+		}
+		srcs = fixPackages(lg, srcs, opts.PackageFixers, pkgCounts)
+		for p, f := range goFiles {
+			if out := srcs[p]; !bytes.Equal(f.in, out) {
+				lg.Info("File needs changes", "path", p, "sha", *f.te.SHA, "fixer", fixerDesc)
+				add(f.te, f.in, out)
+			}
+		}
+	}
+	lg.Info("Found files that need changes", "files", len(changes))
+	res.FilesChanged = len(changes)
+	if opts.ChecksAPI {
+		// The check reports every file, however many or few there are.
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+		lg.Info("Posting check run", "name", checkRunName, "sha", origCommit)
+		run, err := postCheckRun(ctx, gh, owner, repo, origCommit, fixerDesc, diffs)
+		if err != nil {
+			return nil, err
+		}
+		lg.Info("Posted check run", "url", run.HTMLURL)
+		if len(changes) == 0 {
+			return nil, errNoChanges
+		}
+		return nil, nil
+	}
 	if len(changes) == 0 {
-		return
+		return nil, errNoChanges
+	}
+	if len(changes) < opts.MinFilesChanged {
+		lg.Info("Too few files need changes; leaving repo alone", "files", len(changes), "min", opts.MinFilesChanged)
+		return nil, errNoChanges
+	}
+	if opts.ReportOnly {
+		// However many files need changes, the issue lists them all.
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+		is, err := reportIssue(ctx, gh, owner, repo, fixerDesc, diffs)
+		if err != nil {
+			return nil, err
+		}
+		lg.Info("Reported files that need changes", "url", is.GetHTMLURL())
+		return nil, nil
+	}
+	if opts.MaxFilesChanged > 0 && len(changes) > opts.MaxFilesChanged && !opts.SplitPRs {
+		lg.Warn("Too many files need changes; leaving repo alone", "files", len(changes), "max", opts.MaxFilesChanged)
+		return nil, errTooManyChanges
 	}
 
-	log.Printf("Creating fork ...")
-	fork, _, err := gh.Repositories.CreateFork(owner, repo, nil)
-	if err != nil {
-		log.Fatalf("Creating fork: %v", err)
+	sort.Slice(changes, func(i, j int) bool { return *changes[i].Path < *changes[j].Path })
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	if opts.DiffStat {
+		// Written in one go, like the diffs.
+		stat := owner + "/" + repo + ":\n" + diffStat(diffs)
+		if _, err := os.Stdout.WriteString(stat); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Check {
+		// Like gofmt -l, but on stderr so as not to get mixed up with -dry-run's diffs or -json.
+		var buf bytes.Buffer
+		for _, te := range changes {
+			fmt.Fprintln(&buf, *te.Path)
+		}
+		if _, err := os.Stderr.Write(buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun || opts.Patch != nil {
+		// Write the diffs in one go so that they aren't interleaved with other repos'.
+		var buf bytes.Buffer
+		for _, de := range diffs {
+			d, err := de.Diff()
+			if err != nil {
+				return nil, fmt.Errorf("diffing %s: %v", de.Path, err)
+			}
+			buf.WriteString(d)
+		}
+		if opts.DryRun {
+			if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+		if opts.Patch != nil {
+			if _, err := opts.Patch.Write(buf.Bytes()); err != nil {
+				return nil, fmt.Errorf("writing patch: %v", err)
+			}
+		}
+	}
+	if opts.OutputDir != "" {
+		dir := filepath.Join(opts.OutputDir, owner, repo)
+		lg.Info("Writing changed files", "dir", dir)
+		if err := writeFiles(dir, changes); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Check || opts.DryRun || opts.OutputDir != "" || opts.Patch != nil {
+		return nil, nil
+	}
+
+	var report string
+	if len(names) > 1 {
+		for i, name := range names {
+			report += fmt.Sprintf("- %s fixed %d files", name, counts[i])
+			if fixes[i] > 0 {
+				report += fmt.Sprintf(", making %d corrections", fixes[i])
+			}
+			report += "\n"
+		}
+	}
+	var rules []codeownersRule
+	if *assignCodeowners {
+		rules, err = fetchCodeowners(ctx, gh, owner, repo, tree)
+		if err != nil {
+			lg.Warn("Reading CODEOWNERS", "err", err)
+		}
+	}
+	if *checkProtection {
+		if err := checkBranchProtection(ctx, gh, owner, repo, branch); err != nil {
+			lg.Warn("Checking branch protection", "branch", branch, "err", err)
+		}
+	}
+	if *closeStale {
+		if err := closeStalePRs(ctx, gh, owner, repo); err != nil {
+			return nil, fmt.Errorf("closing stale pull requests: %v", err)
+		}
+	}
+	if *cleanupBranch {
+		// Merged pull requests' branches may be reused by the new ones, so they go first.
+		prs, _, err := prbotPRs(ctx, gh, owner, repo, "closed")
+		if err == nil {
+			err = deleteMergedBranches(ctx, gh, owner, repo, prs)
+		}
+		if err != nil {
+			lg.Warn("Deleting branches of merged pull requests", "err", err)
+		}
+	}
+	if opts.PerFilePRs {
+		return makePerFilePullRequests(ctx, gh, owner, repo, branch, origCommit, *tree.SHA, changes, fixerDesc, rules)
+	}
+	if opts.MaxFilesChanged == 0 || len(changes) <= opts.MaxFilesChanged {
+		return makePullRequest(ctx, gh, owner, repo, branch, prBranch, prPart{}, origCommit, *tree.SHA, changes, fixerDesc, report, codeowners(rules, changes))
+	}
+
+	parts := chunk(changes, opts.MaxFilesChanged)
+	lg.Info("Splitting changes into several pull requests", "files", len(changes), "prs", len(parts))
+	var first *github.PullRequest
+	err = errPROpen
+	for i, part := range parts {
+		head := fmt.Sprintf("%s-%d", prBranch, i+1)
+		if first != nil {
+			if err := prPause(ctx); err != nil {
+				return first, err
+			}
+		}
+		pr, perr := makePullRequest(ctx, gh, owner, repo, branch, head, prPart{N: i + 1, Of: len(parts)}, origCommit, *tree.SHA, part, fixerDesc, report, codeowners(rules, part))
+		if perr == errPROpen {
+			continue
+		}
+		if perr != nil {
+			return first, perr
+		}
+		if first == nil {
+			first, err = pr, nil
+		}
+	}
+	return first, err
+}
+
+// makePerFilePullRequests makes a pull request for each of changes,
+// as for -per-file-pr, stopping once prQuota runs out.
+// It returns the first pull request made.
+func makePerFilePullRequests(ctx context.Context, gh *github.Client, owner, repo, branch, origCommit, baseTree string, changes []github.TreeEntry, fixerDesc string, rules []codeownersRule) (*github.PullRequest, error) {
+	lg := slog.With("repo", owner+"/"+repo)
+	lg.Info("Making a pull request for each file", "files", len(changes))
+	var first *github.PullRequest
+	err := errPROpen
+	for _, te := range changes {
+		if !prQuota.take() {
+			lg.Warn("Reached -per-file-pr-limit; leaving the remaining files alone", "limit", *perFilePRLimit)
+			if first == nil {
+				err = errTooManyChanges
+			}
+			break
+		}
+		if first != nil {
+			if err := prPause(ctx); err != nil {
+				prQuota.put()
+				return first, err
+			}
+		}
+		file := []github.TreeEntry{te}
+		head := prBranch + "-" + branchSafe(*te.Path)
+		// The counts of changes made by each fixer are for the whole repo, so are left out.
+		pr, perr := makePullRequest(ctx, gh, owner, repo, branch, head, prPart{File: *te.Path}, origCommit, baseTree, file, fixerDesc, "", codeowners(rules, file))
+		if perr != nil {
+			prQuota.put()
+		}
+		if perr == errPROpen {
+			continue
+		}
+		if perr != nil {
+			return first, perr
+		}
+		if first == nil {
+			first, err = pr, nil
+		}
+	}
+	return first, err
+}
+
+// makePullRequest commits changes on top of origCommit (whose tree is baseTree)
+// to headBranch in a fork of github.com/owner/repo,
+// and makes a pull request from that fork against branch.
+// part says which of several pull requests for the repo this is, if any.
+// Reviews are requested from owners, as well as the -reviewer users and teams.
+// fixerDesc names the fixers that made the changes, and report,
+// if not empty, lists how many files each one changed.
+// Unless -force is set, it returns errPROpen if prbot already has a pull request open,
+// or with -update-pr force-pushes the changes to that pull request's branch instead.
+func makePullRequest(ctx context.Context, gh *github.Client, owner, repo, branch, headBranch string, part prPart, origCommit, baseTree string, changes []github.TreeEntry, fixerDesc, report string, owners []string) (*github.PullRequest, error) {
+	lg := slog.With("repo", owner+"/"+repo)
+	// existing is the open pull request to update, for -update-pr.
+	var existing *github.PullRequest
+	if !*force {
+		headOwner, err := forkOwner(ctx, gh, owner)
+		if err != nil {
+			return nil, err
+		}
+		prs, _, err := gh.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+			State: "open",
+			Head:  headOwner + ":" + headBranch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing pull requests: %v", err)
+		}
+		switch {
+		case len(prs) > 0 && *updatePR:
+			existing = prs[0]
+			lg.Info("Updating open pull request", "url", existing.GetHTMLURL())
+		case len(prs) > 0:
+			lg.Info("Pull request already open", "url", prs[0].GetHTMLURL())
+			return nil, errPROpen
+		}
+	}
+
+	// The new branch goes in headOwner/headRepo, which is a fork
+	// unless -no-fork is set, and head identifies it to GitHub.
+	headOwner, headRepo, head := owner, repo, headBranch
+	// opened records whether the pull request has been made, for cleaning up on interruption.
+	opened := false
+	switch {
+	case existing != nil:
+		// The branch is already wherever the pull request says.
+		r := existing.GetHead().GetRepo()
+		headOwner, headRepo = r.GetOwner().GetLogin(), r.GetName()
+	case *existingFork != "":
+		var fork *github.Repository
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			fork, _, err = gh.Repositories.Get(ctx, *existingFork, repo)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting existing fork: %v", err)
+		}
+		if !fork.GetFork() {
+			return nil, fmt.Errorf("github.com/%s/%s is not a fork", *existingFork, repo)
+		}
+		if *validateForkPerm {
+			if err := checkPush(fork); err != nil {
+				return nil, err
+			}
+		}
+		lg.Info("Using existing fork", "url", fork.GetHTMLURL())
+		headOwner, headRepo = *fork.Owner.Login, *fork.Name
+		head = headOwner + ":" + headBranch
+	case *noFork:
+		if !*validateForkPerm {
+			break
+		}
+		var r *github.Repository
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			r, _, err = gh.Repositories.Get(ctx, owner, repo)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting repo: %v", err)
+		}
+		if err := checkPush(r); err != nil {
+			return nil, err
+		}
+	default:
+		lg.Info("Creating fork")
+		var fork *github.Repository
+		start := time.Now()
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			var opt *github.RepositoryCreateForkOptions
+			if *forkOrg != "" {
+				opt = &github.RepositoryCreateForkOptions{Organization: *forkOrg}
+			}
+			fork, _, err = gh.Repositories.CreateFork(ctx, owner, repo, opt)
+			return err
+		})
+		if _, ok := err.(*github.AcceptedError); ok {
+			// GitHub creates the fork asynchronously, but has told us where it will be.
+			err = nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("creating fork: %v", err)
+		}
+		lg.Info("Created fork", "url", *fork.HTMLURL)
+		// GitHub returns any existing fork, which must be left alone.
+		// Allow for some clock skew in telling whether this one is new.
+		if *cleanupOnCancel && fork.GetCreatedAt().Time.After(start.Add(-time.Minute)) {
+			owner, name := *fork.Owner.Login, *fork.Name
+			defer func() {
+				if opened || ctx.Err() == nil {
+					return
+				}
+				lg.Warn("Interrupted; deleting new fork", "url", fork.GetHTMLURL())
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+				defer cancel()
+				if _, err := gh.Repositories.Delete(ctx, owner, name); err != nil {
+					lg.Error("Deleting fork", "url", fork.GetHTMLURL(), "err", err)
+				}
+			}()
+		}
+		if err := waitForFork(ctx, gh, *fork.Owner.Login, *fork.Name, branch); err != nil {
+			return nil, err
+		}
+		headOwner, headRepo = *fork.Owner.Login, *fork.Name
+		head = headOwner + ":" + headBranch
 	}
-	//log.Printf("Fork: %v", fork)
-	log.Printf("Fork URL: %v", *fork.HTMLURL)
-	// TODO: Do we need to poll until the fork is ready?
 
-	log.Printf("Creating new tree ...")
-	newTree, _, err := gh.Git.CreateTree(*fork.Owner.Login, *fork.Name, *tree.SHA, changes)
+	lg.Info("Creating new tree")
+	var newTree *github.Tree
+	err := withRetry(ctx, *maxRetries, func() (err error) {
+		newTree, _, err = gh.Git.CreateTree(ctx, headOwner, headRepo, baseTree, changes)
+		return err
+	})
 	if err != nil {
-		log.Fatalf("Creating tree: %v", err)
+		return nil, fmt.Errorf("creating tree: %v", err)
 	}
-	log.Printf("New tree: %s", *newTree.SHA)
+	lg.Info("Created new tree", "sha", *newTree.SHA)
 
-	log.Printf("Creating commit ...")
-	comm, _, err := gh.Git.CreateCommit(*fork.Owner.Login, *fork.Name, &github.Commit{
-		Message: github.String("Run gofmt over Go source files."),
+	lg.Info("Creating commit")
+	msg, err := commitMessage(owner, repo, fixerDesc, report, len(changes))
+	if err != nil {
+		return nil, err
+	}
+	commit := &github.Commit{
+		Message: github.String(msg),
 		Tree:    &github.Tree{SHA: newTree.SHA},
 		Parents: []github.Commit{
 			{SHA: github.String(origCommit)},
 		},
+	}
+	if *commitAuthorName != "" {
+		// Otherwise GitHub uses the authenticated user.
+		now := time.Now()
+		author := &github.CommitAuthor{
+			Name:  github.String(*commitAuthorName),
+			Email: github.String(*commitAuthorEmail),
+			Date:  &now,
+		}
+		commit.Author, commit.Committer = author, author
+	}
+	var comm *github.Commit
+	err = withRetry(ctx, *maxRetries, func() (err error) {
+		comm, _, err = gh.Git.CreateCommit(ctx, headOwner, headRepo, commit)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating commit: %v", err)
+	}
+	lg.Info("Created commit", "sha", *comm.SHA)
+
+	title, body := *prTitle, *prBody
+	if title == "" {
+		title = fixerDesc + " everything"
+	}
+	title += titleSuffix
+	if p := part.String(); p != "" {
+		title += " (" + p + ")"
+	}
+	if body == "" {
+		body, err = prBodyText(owner, repo, branch, fixerDesc, report, changes, part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(linkIssues) > 0 {
+		body += "\n\n" + issueLinks()
+	}
+	if existing != nil {
+		lg.Info("Updating branch", "branch", headBranch)
+		err = withRetry(ctx, *maxRetries, func() error {
+			_, _, err := gh.Git.UpdateRef(ctx, headOwner, headRepo, &github.Reference{
+				Ref:    github.String("refs/heads/" + headBranch),
+				Object: &github.GitObject{SHA: comm.SHA},
+			}, true)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("updating branch: %v", err)
+		}
+		// The files changed may have too.
+		var pr *github.PullRequest
+		err = withRetry(ctx, *maxRetries, func() (err error) {
+			pr, _, err = gh.PullRequests.Edit(ctx, owner, repo, existing.GetNumber(), &github.PullRequest{
+				Body: github.String(body),
+			})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("updating pull request: %v", err)
+		}
+		lg.Info("Updated pull request", "url", pr.GetHTMLURL())
+		return pr, nil
+	}
+
+	lg.Info("Creating branch", "branch", headBranch)
+	err = withRetry(ctx, *maxRetries, func() error {
+		_, _, err := gh.Git.CreateRef(ctx, headOwner, headRepo, &github.Reference{
+			Ref: github.String("refs/heads/" + headBranch),
+			Object: &github.GitObject{
+				Type: github.String("commit"),
+				SHA:  comm.SHA,
+			},
+		})
+		return err
 	})
 	if err != nil {
-		log.Fatalf("Creating commit: %v", err)
+		return nil, fmt.Errorf("creating branch: %v", err)
 	}
-	log.Printf("Commit: %s", *comm.SHA)
+	lg.Info("Created branch", "branch", head)
 
-	log.Printf("Creating branch ...")
-	prBranch := "prbot-gofmt"
-	ref, _, err = gh.Git.CreateRef(*fork.Owner.Login, *fork.Name, &github.Reference{
-		Ref: github.String("refs/heads/" + prBranch),
-		Object: &github.GitObject{
-			Type: github.String("commit"),
-			SHA:  comm.SHA,
+	lg.Info("Creating pull request")
+	pull := &newPullRequest{
+		NewPullRequest: github.NewPullRequest{
+			Title: github.String(title),
+			Head:  github.String(head),
+			Base:  github.String(branch),
+			Body:  github.String(body),
 		},
+		Draft: *draft,
+	}
+	if !*noFork {
+		// It only means anything for branches in forks.
+		pull.MaintainerCanModify = github.Bool(*maintainerEdits)
+	}
+	var pr *github.PullRequest
+	err = withRetry(ctx, *maxRetries, func() (err error) {
+		pr, err = createPullRequest(ctx, gh, owner, repo, pull)
+		return err
 	})
 	if err != nil {
-		log.Fatalf("Creating branch: %v", err)
+		return nil, fmt.Errorf("creating pull request: %v", err)
+	}
+	lg.Info("Created pull request", "url", *pr.HTMLURL)
+	opened = true
+
+	if len(labels) > 0 {
+		lg.Info("Adding labels", "labels", []string(labels))
+		if err := addLabels(ctx, gh, owner, repo, pr.GetNumber(), labels); err != nil {
+			lg.Warn("Adding labels", "url", *pr.HTMLURL, "err", err)
+		}
+	}
+	if *prMilestone != "" {
+		lg.Info("Setting milestone", "milestone", *prMilestone)
+		if err := setMilestone(ctx, gh, owner, repo, pr.GetNumber()); err != nil {
+			lg.Warn("Setting milestone", "url", *pr.HTMLURL, "err", err)
+		}
+	}
+	if len(reactions) > 0 {
+		lg.Info("Adding reactions", "reactions", []string(reactions))
+		for _, r := range reactions {
+			if _, _, err := gh.Reactions.CreateIssueReaction(ctx, owner, repo, pr.GetNumber(), r); err != nil {
+				lg.Warn("Adding reaction", "url", *pr.HTMLURL, "reaction", r, "err", err)
+			}
+		}
+	}
+	if len(assignees) > 0 {
+		lg.Info("Adding assignees", "assignees", []string(assignees))
+		if err := addAssignees(ctx, gh, owner, repo, pr.GetNumber(), pr.GetUser().GetLogin()); err != nil {
+			lg.Warn("Adding assignees", "url", *pr.HTMLURL, "err", err)
+		}
+	}
+	rs := append(append([]string(nil), reviewers...), owners...)
+	if len(rs) > 0 {
+		lg.Info("Requesting reviews", "reviewers", rs)
+		if err := requestReviewers(ctx, gh, owner, repo, pr.GetNumber(), pr.GetUser().GetLogin(), rs); err != nil {
+			lg.Warn("Requesting reviews", "url", *pr.HTMLURL, "err", err)
+		}
+	}
+	if *autoMerge {
+		lg.Info("Enabling auto-merge", "method", *autoMergeMethod)
+		if err := enableAutoMerge(ctx, v4, pr.GetNodeID(), mergeMethods[*autoMergeMethod]); err != nil {
+			// The repo may not allow auto-merge, or the branch may have no required checks.
+			lg.Warn("Enabling auto-merge", "url", *pr.HTMLURL, "err", err)
+		}
+	}
+	return pr, nil
+}
+
+// commitMessage returns the message for the commit of n changed files
+// in github.com/owner/repo, as set by -commit-message or -commit-message-template.
+func commitMessage(owner, repo, fixerDesc, report string, n int) (string, error) {
+	if *commitMsg != "" {
+		return *commitMsg, nil
+	}
+	if commitTmpl != nil {
+		var buf bytes.Buffer
+		err := commitTmpl.Execute(&buf, struct {
+			FilesChanged int
+			Fixer        string
+			RepoName     string
+		}{n, fixerDesc, owner + "/" + repo})
+		if err != nil {
+			return "", fmt.Errorf("executing -commit-message-template: %v", err)
+		}
+		return buf.String(), nil
+	}
+	what := "Go source files"
+	if *fixWhitespace {
+		what = "source files"
+	}
+	return strings.TrimSpace(fmt.Sprintf("Run %s over %s.\n\n%s", fixerDesc, what, report)), nil
+}
+
+// fixPackages runs pkgFixers in turn over the files in srcs, keyed by path,
+// a directory at a time. It returns the new sources, keyed by path.
+// It atomically increments counts[i] for each file that pkgFixers[i] changes.
+// Errors are logged, since the files can still be fixed by the other package fixers.
+func fixPackages(lg *slog.Logger, srcs map[string][]byte, pkgFixers []PackageFixer, counts []int64) map[string][]byte {
+	dirs := make(map[string]map[string][]byte)
+	for p, src := range srcs {
+		d := path.Dir(p)
+		if dirs[d] == nil {
+			dirs[d] = make(map[string][]byte)
+		}
+		dirs[d][p] = src
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	out := make(map[string][]byte, len(srcs))
+	sem := make(chan struct{}, *concurrency)
+	for d, files := range dirs {
+		d, files := d, files
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for i, pf := range pkgFixers {
+				changed, err := pf.FixPackage(files)
+				if err != nil {
+					lg.Warn("Package fixer failed", "dir", d, "fixer", pf.Name(), "err", err)
+					continue
+				}
+				for p, src := range changed {
+					if _, ok := files[p]; ok && !bytes.Equal(files[p], src) {
+						files[p] = src
+						atomic.AddInt64(&counts[i], 1)
+					}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for p, src := range files {
+				out[p] = src
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// A syncWriter is an io.Writer that is safe for concurrent use.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// writeFiles writes the contents of changes to files under dir.
+func writeFiles(dir string, changes []github.TreeEntry) error {
+	for _, te := range changes {
+		if !filepath.IsLocal(*te.Path) {
+			return fmt.Errorf("refusing to write %q outside %s", *te.Path, dir)
+		}
+		name := filepath.Join(dir, filepath.FromSlash(*te.Path))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+		perm := os.FileMode(0666)
+		if te.GetMode() == "100755" {
+			perm = 0777
+		}
+		if err := ioutil.WriteFile(name, []byte(*te.Content), perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldProcess reports whether te is a file that prbot should try to fix.
+func shouldProcess(te github.TreeEntry) bool {
+	// Mode 120000 is a symlink, whose content is the link target.
+	if *te.Type != "blob" || te.GetMode() == "120000" {
+		return false
+	}
+	if !isGoFile(te) && !*fixWhitespace {
+		return false
+	}
+	return true
+}
+
+// skipFile reports whether prbot should leave alone te, a file that shouldProcess accepted,
+// given the repo's -ignore-file rules and its modules, logging why it should.
+// Files that match a -skip-path are logged at debug level, since they are skipped on every run
+// just as asked, and files skipped for any other reason as a warning.
+func skipFile(lg *slog.Logger, te github.TreeEntry, ignore ignoreRules, mods []goModule) bool {
+	for _, pat := range skipPaths {
+		if matchPath(pat, *te.Path) {
+			lg.Debug("Skipping file because it matches -skip-path", "path", *te.Path, "pattern", pat)
+			return true
+		}
 	}
-	//log.Printf("Branch: %v", ref)
-	log.Printf("Branch URL: %s/tree/%s", *fork.HTMLURL, prBranch)
+	why := skipReason(te)
+	if why == "" && ignore.ignored(*te.Path) {
+		why = "it matches a pattern in " + *ignoreFile
+	}
+	if why == "" && *moduleAware && isGoFile(te) && moduleOf(*te.Path, mods) == nil {
+		why = "it is not in a module"
+	}
+	if why == "" {
+		return false
+	}
+	lg.Warn("Skipping file because "+why, "path", *te.Path)
+	return true
+}
+
+// skipReason returns why prbot should not touch te, a file that shouldProcess accepted,
+// or the empty string if there is no such reason.
+func skipReason(te github.TreeEntry) string {
+	// Safety measure; let's stick with files that aren't too big.
+	if te.Size != nil && *te.Size > int(maxFileSize) {
+		return fmt.Sprintf("it is too big (%d bytes, over the -max-file-size limit of %v)", *te.Size, &maxFileSize)
+	}
+	if *excludeVendor && inVendor(*te.Path) {
+		return "it is vendored"
+	}
+	if *skipTestFiles && strings.HasSuffix(*te.Path, "_test.go") {
+		return "it is a test file"
+	}
+	return ""
+}
+
+// inVendor reports whether the file at path p is in a vendor directory.
+func inVendor(p string) bool {
+	elems := strings.Split(p, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		if elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether name matches the glob pattern.
+// Each slash-separated element of pattern matches an element of name
+// as for path.Match, except that "**" matches any number of elements.
+func matchPath(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// isGoFile reports whether te is a Go source file, with one of the -file-extensions.
+func isGoFile(te github.TreeEntry) bool {
+	for _, ext := range goExts {
+		if strings.HasSuffix(*te.Path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// newClient returns a GitHub API client that uses httpClient,
+// talking to GitHub Enterprise if -github-url is set.
+func newClient(httpClient *http.Client) (*github.Client, error) {
+	gh := github.NewClient(httpClient)
+	if *githubURL != "" {
+		uploadURL := *githubUploadURL
+		if uploadURL == "" {
+			uploadURL = *githubURL
+		}
+		var err error
+		gh, err = github.NewEnterpriseClient(*githubURL, uploadURL, httpClient)
+		if err != nil {
+			return nil, err
+		}
+	} else if *githubUploadURL != "" {
+		return nil, errors.New("-github-upload-url requires -github-url")
+	}
+	gh.UserAgent = "prbot/0.1"
+	return gh, nil
+}
 
-	log.Printf("Creating pull request ...")
-	pr, _, err := gh.PullRequests.Create(owner, repo, &github.NewPullRequest{
-		Title: github.String("gofmt everything"),
-		Head:  github.String(*fork.Owner.Login + ":" + prBranch),
-		Base:  github.String(branch),
-		Body:  github.String("I ran gofmt over this repository using prbot, an automated tool."),
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// fatal logs msg and its key-value attributes as an error, then exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// resolveTag returns the SHA of the commit that tag points at in github.com/owner/repo,
+// following annotated tags, which point at a tag object, to the commit they tag.
+func resolveTag(ctx context.Context, gh *github.Client, owner, repo, tag string) (string, error) {
+	var ref *github.Reference
+	err := withRetry(ctx, *maxRetries, func() (err error) {
+		ref, _, err = gh.Git.GetRef(ctx, owner, repo, "refs/tags/"+tag)
+		return err
 	})
 	if err != nil {
-		log.Fatalf("Creating pull request: %v", err)
+		return "", fmt.Errorf("getting tag %s: %v", tag, err)
+	}
+	obj := ref.Object
+	// A tag can tag another tag, but not endlessly.
+	for i := 0; obj.GetType() == "tag" && i < 10; i++ {
+		var t *github.Tag
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			t, _, err = gh.Git.GetTag(ctx, owner, repo, obj.GetSHA())
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("getting tag object of %s: %v", tag, err)
+		}
+		obj = t.Object
 	}
-	log.Printf("Pull request: %s", *pr.HTMLURL)
+	if obj.GetType() != "commit" {
+		return "", fmt.Errorf("tag %s does not point at a commit", tag)
+	}
+	return obj.GetSHA(), nil
 }
 
-func rawBlob(gh *github.Client, owner, repo, sha1 string) ([]byte, error) {
+// repoContext returns the context to process a repo in, for -per-repo-timeout:
+// ctx, with the timeout if there is one.
+func repoContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *repoTimeout > 0 {
+		return context.WithTimeout(ctx, *repoTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// waitForFork polls until the fork owner/repo, made from a repo with the given branch, has been provisioned
+// well enough to push to, giving up after -fork-wait-timeout.
+func waitForFork(ctx context.Context, gh *github.Client, owner, repo, branch string) error {
+	deadline := time.Now().Add(*forkWaitTimeout)
+	for delay := 2 * time.Second; ; delay *= 2 {
+		r, _, err := gh.Repositories.Get(ctx, owner, repo)
+		if err == nil && r.GetSize() > 0 {
+			return nil
+		}
+		if _, _, err := gh.Git.GetRef(ctx, owner, repo, "refs/heads/"+branch); err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("fork github.com/%s/%s is still not ready after %v; try again later, or raise -fork-wait-timeout", owner, repo, *forkWaitTimeout)
+		}
+		slog.Info("Waiting for fork to be ready", "repo", owner+"/"+repo, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func rawBlob(ctx context.Context, gh *github.Client, rl *rateLimiter, owner, repo, sha1 string) ([]byte, error) {
+	if data, ok := cache.get(sha1); ok {
+		return data, nil
+	}
 	// gh.Git.GetBlob only permits getting the base64 version.
+	// The URL is relative, so gh.NewRequest resolves it against gh.BaseURL,
+	// which is what makes this work against GitHub Enterprise too.
 	u := fmt.Sprintf("repos/%v/%v/git/blobs/%v", owner, repo, sha1)
 	req, err := gh.NewRequest("GET", u, nil)
 	if err != nil {
@@ -196,9 +2016,23 @@ func rawBlob(gh *github.Client, owner, repo, sha1 string) ([]byte, error) {
 	}
 	req.Header.Set("Accept", "application/vnd.github.v3.raw")
 
-	var buf bytes.Buffer
-	if _, err = gh.Do(req, &buf); err != nil {
-		return nil, err
+	for {
+		if err := rl.wait(ctx); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		resp, err := gh.Do(ctx, req, &buf)
+		rl.update(resp)
+		if _, ok := err.(*github.RateLimitError); ok {
+			// rl.wait will hold off until the quota is reset.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := cache.put(sha1, buf.Bytes()); err != nil {
+			slog.Warn("Caching blob", "sha", sha1, "err", err)
+		}
+		return buf.Bytes(), nil
 	}
-	return buf.Bytes(), nil
 }
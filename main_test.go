@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"a.go", "a.go", true},
+		{"a.go", "dir/a.go", false},
+		{"*.go", "a.go", true},
+		{"*.go", "dir/a.go", false},
+		{"dir/*.go", "dir/a.go", true},
+		{"dir/*.go", "dir/sub/a.go", false},
+		{"**/a.go", "a.go", true},
+		{"**/a.go", "dir/sub/a.go", true},
+		{"dir/**", "dir/a.go", true},
+		{"dir/**", "dir/sub/a.go", true},
+		{"dir/**", "other/a.go", false},
+		{"dir/**/*_test.go", "dir/a_test.go", true},
+		{"dir/**/*_test.go", "dir/sub/a_test.go", true},
+		{"dir/**/*_test.go", "dir/sub/a.go", false},
+		{"vendor/**", "vendor", true},
+		{"[ab].go", "b.go", true},
+		{"a?.go", "ab.go", true},
+		{"a?.go", "a/.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// These are the metrics served by -metrics-addr.
+var (
+	reposProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prbot_repos_processed_total",
+		Help: "Repos processed.",
+	})
+	filesChanged = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prbot_files_changed_total",
+		Help: "Files found to need changes.",
+	})
+	prsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prbot_prs_created_total",
+		Help: "Pull requests made.",
+	})
+	apiErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prbot_api_errors_total",
+		Help: "GitHub API requests that failed.",
+	})
+	runDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prbot_run_duration_seconds",
+		Help:    "How long processing a repo took.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12), // up to about an hour
+	})
+	reposInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "prbot_repos_in_progress",
+		Help: "Repos being processed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reposProcessed, filesChanged, prsCreated, apiErrors, runDuration, reposInProgress)
+}
+
+// serveMetrics serves the metrics at /metrics on addr, in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Serving metrics", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// startRepo records that a repo is being processed.
+// The returned function records the outcome, given what processRepo returned.
+func startRepo() func(res *repoResult, pr *github.PullRequest) {
+	start := time.Now()
+	reposInProgress.Inc()
+	return func(res *repoResult, pr *github.PullRequest) {
+		reposInProgress.Dec()
+		runDuration.Observe(time.Since(start).Seconds())
+		reposProcessed.Inc()
+		filesChanged.Add(float64(res.FilesChanged))
+		if pr != nil {
+			prsCreated.Inc()
+		}
+	}
+}
+
+// countingTransport counts the requests that fail with an error
+// or an error status as apiErrors.
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 400 {
+		apiErrors.Inc()
+	}
+	return resp, err
+}
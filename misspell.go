@@ -0,0 +1,34 @@
+package main
+
+import "github.com/client9/misspell"
+
+// MisspellFixer corrects commonly misspelled English words
+// in the comments of Go source files, using misspell.
+// It leaves identifiers and string literals alone, since changing those
+// could change what the program does.
+type MisspellFixer struct {
+	r *misspell.Replacer
+}
+
+// newMisspellFixer returns a MisspellFixer that doesn't correct
+// the misspellings in ignore.
+func newMisspellFixer(ignore []string) MisspellFixer {
+	r := misspell.New()
+	if len(ignore) > 0 {
+		r.RemoveRule(ignore)
+		r.Compile()
+	}
+	return MisspellFixer{r}
+}
+
+func (MisspellFixer) Name() string { return "misspell" }
+
+func (f MisspellFixer) Fix(filename string, src []byte) ([]byte, error) {
+	out, _, err := f.FixN(filename, src)
+	return out, err
+}
+
+func (f MisspellFixer) FixN(filename string, src []byte) ([]byte, int, error) {
+	out, diffs := f.r.ReplaceGo(string(src))
+	return []byte(out), len(diffs), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/build/constraint"
+	"go/version"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/mod/modfile"
+)
+
+// A goModule is a module declared by a go.mod file.
+type goModule struct {
+	Root      string // the directory of the go.mod file, with "." for the root of the repo
+	GoVersion string // from the go directive, such as 1.22, or "" if there is none
+}
+
+// parseGoMod returns the module declared by data, the go.mod file at path p,
+// reporting whether it declares one: a go.mod file without a module directive doesn't.
+func parseGoMod(p string, data []byte) (goModule, bool) {
+	f, err := modfile.ParseLax(p, data, nil)
+	if err != nil || f.Module == nil {
+		return goModule{}, false
+	}
+	m := goModule{Root: path.Dir(p)}
+	if f.Go != nil {
+		m.GoVersion = f.Go.Version
+	}
+	return m, true
+}
+
+// goModules returns the modules declared by the go.mod files
+// in tree, the tree of github.com/owner/repo.
+func goModules(ctx context.Context, gh *github.Client, owner, repo string, tree *github.Tree) ([]goModule, error) {
+	var mods []goModule
+	for _, te := range tree.Entries {
+		if te.GetType() != "blob" || path.Base(te.GetPath()) != "go.mod" {
+			continue
+		}
+		var data []byte
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			data, err = rawBlob(ctx, gh, rl, owner, repo, te.GetSHA())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		m, ok := parseGoMod(te.GetPath(), data)
+		if !ok {
+			slog.Warn("Ignoring go.mod without a module directive", "repo", owner+"/"+repo, "path", te.GetPath())
+			continue
+		}
+		mods = append(mods, m)
+	}
+	return mods, nil
+}
+
+// moduleOf returns the module of mods that the file at path p is in,
+// the innermost if they are nested, or nil if it is in none of them.
+func moduleOf(p string, mods []goModule) *goModule {
+	var in *goModule
+	for i, m := range mods {
+		if (m.Root == "." || strings.HasPrefix(p, m.Root+"/")) && (in == nil || len(m.Root) > len(in.Root) || in.Root == ".") {
+			in = &mods[i]
+		}
+	}
+	return in
+}
+
+// goVersionAtLeast reports whether file, the Go file at path p,
+// is written for Go version v, such as go1.22, or later:
+// whether it is in one of mods, with a go directive of at least v,
+// and doesn't have a //go:build line that asks for an older version.
+func goVersionAtLeast(p string, file *ast.File, mods []goModule, v string) bool {
+	m := moduleOf(p, mods)
+	if m == nil || version.Compare("go"+m.GoVersion, v) < 0 {
+		return false
+	}
+	fv := fileGoVersion(file)
+	return fv == "" || version.Compare(fv, v) >= 0
+}
+
+// fileGoVersion returns the minimum Go version, such as go1.21,
+// that file's //go:build line requires, or "" if there is none.
+func fileGoVersion(file *ast.File) string {
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) {
+				if expr, err := constraint.Parse(c.Text); err == nil {
+					return constraint.GoVersion(expr)
+				}
+			}
+		}
+	}
+	return ""
+}
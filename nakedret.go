@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// NakedReturnFixer makes the naked returns in functions longer than MaxLength lines
+// explicit, like those the nakedret linter reports, by naming the results they return.
+// Go rejects naked returns where a result is shadowed,
+// so naming the results never changes what is returned.
+type NakedReturnFixer struct {
+	MaxLength int
+}
+
+func (NakedReturnFixer) Name() string { return "nakedret" }
+
+func (f NakedReturnFixer) Fix(filename string, src []byte) ([]byte, error) {
+	out, _, err := f.FixN(filename, src)
+	return out, err
+}
+
+func (f NakedReturnFixer) FixN(filename string, src []byte) ([]byte, int, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, 0, err
+	}
+	tf := fset.File(file.Pos())
+
+	type insert struct {
+		off  int
+		text string
+	}
+	var inserts []insert
+	ast.Inspect(file, func(n ast.Node) bool {
+		var typ *ast.FuncType
+		var body *ast.BlockStmt
+		switch fn := n.(type) {
+		case *ast.FuncDecl:
+			typ, body = fn.Type, fn.Body
+		case *ast.FuncLit:
+			typ, body = fn.Type, fn.Body
+		default:
+			return true
+		}
+		if body == nil {
+			return true
+		}
+		if lines := fset.Position(body.End()).Line - fset.Position(n.Pos()).Line + 1; lines <= f.MaxLength {
+			return true
+		}
+		names := resultNames(typ)
+		if names == "" {
+			return true
+		}
+		ast.Inspect(body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				// Its returns are its own, and the outer Inspect gets to them.
+				return false
+			case *ast.ReturnStmt:
+				if len(n.Results) == 0 {
+					inserts = append(inserts, insert{tf.Offset(n.Pos()) + len("return"), " " + names})
+				}
+			}
+			return true
+		})
+		return true
+	})
+	if len(inserts) == 0 {
+		return src, 0, nil
+	}
+
+	sort.Slice(inserts, func(i, j int) bool { return inserts[i].off < inserts[j].off })
+	var out []byte
+	last := 0
+	for _, ins := range inserts {
+		out = append(out, src[last:ins.off]...)
+		out = append(out, ins.text...)
+		last = ins.off
+	}
+	out = append(out, src[last:]...)
+	return out, len(inserts), nil
+}
+
+// resultNames returns the names of the results of a function of type typ,
+// separated by commas, or "" if its results aren't named,
+// or any are named _ and so can't be returned by name.
+func resultNames(typ *ast.FuncType) string {
+	if typ.Results == nil {
+		return ""
+	}
+	var names []string
+	for _, field := range typ.Results.List {
+		if len(field.Names) == 0 {
+			return ""
+		}
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				return ""
+			}
+			names = append(names, name.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestNakedReturnFixer(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "long function",
+			src: `package p
+
+func f() (n int, err error) {
+	n = 1
+	if n > 0 {
+		return
+	}
+	n++
+	return
+}
+`,
+			want: `package p
+
+func f() (n int, err error) {
+	n = 1
+	if n > 0 {
+		return n, err
+	}
+	n++
+	return n, err
+}
+`,
+		},
+		{
+			name: "short function",
+			src: `package p
+
+func f() (n int) {
+	n = 1
+	return
+}
+`,
+		},
+		{
+			name: "unnamed result",
+			src: `package p
+
+func f() (_ int, err error) {
+	println()
+	println()
+	println()
+	println()
+	return
+}
+`,
+		},
+		{
+			name: "function literal",
+			src: `package p
+
+func f() (n int) {
+	g := func() (s string) {
+		s = "x"
+		println()
+		println()
+		println()
+		return
+	}
+	n = len(g())
+	return
+}
+`,
+			want: `package p
+
+func f() (n int) {
+	g := func() (s string) {
+		s = "x"
+		println()
+		println()
+		println()
+		return s
+	}
+	n = len(g())
+	return n
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.src
+			}
+			got, err := NakedReturnFixer{MaxLength: 5}.Fix("p.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
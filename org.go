@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// listOrgRepos returns all the repos in the GitHub organization org
+// with the given visibility: all, public or private.
+func listOrgRepos(ctx context.Context, gh *github.Client, org, visibility string) ([]*github.Repository, error) {
+	opt := &github.RepositoryListByOrgOptions{
+		Type:        visibility,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.Repository
+	for {
+		repos, resp, err := gh.Repositories.ListByOrg(ctx, org, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, repos...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// searchRepos returns up to limit repos found by the GitHub repository search query.
+func searchRepos(ctx context.Context, gh *github.Client, query string, limit int) ([]*github.Repository, error) {
+	opt := &github.SearchOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.Repository
+	for len(all) < limit {
+		res, resp, err := gh.Search.Repositories(ctx, query, opt)
+		if err != nil {
+			return nil, err
+		}
+		for i := range res.Repositories {
+			if len(all) == limit {
+				break
+			}
+			all = append(all, &res.Repositories[i])
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// readReposFile returns the repos listed in the named file, or stdin if name is "-".
+// Each line holds a repo; blank lines and lines starting with # are ignored.
+func readReposFile(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var repos []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repos = append(repos, line)
+	}
+	return repos, sc.Err()
+}
+
+// hasTopic reports whether r has any of topics.
+func hasTopic(r *github.Repository, topics []string) bool {
+	for _, t := range r.Topics {
+		for _, want := range topics {
+			if strings.EqualFold(t, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// This file holds helpers for making pull requests, and the steps that follow.
+
+// maxListedFiles is how many changed files a pull request body lists
+// before tucking the rest away in a collapsed section.
+const maxListedFiles = 20
+
+// fileList returns a Markdown list of the files that changes modifies.
+func fileList(changes []github.TreeEntry) string {
+	var paths []string
+	for _, te := range changes {
+		paths = append(paths, *te.Path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	buf.WriteString("Files changed:\n\n")
+	for i, p := range paths {
+		if i == maxListedFiles {
+			fmt.Fprintf(&buf, "\n<details>\n<summary>%d more files</summary>\n\n", len(paths)-i)
+		}
+		fmt.Fprintf(&buf, "- `%s`\n", p)
+	}
+	if len(paths) > maxListedFiles {
+		buf.WriteString("\n</details>\n")
+	}
+	return buf.String()
+}
+
+// defaultPRBody is the template for pull request bodies,
+// unless -pr-body-template is set.
+const defaultPRBody = `I ran {{.Fixer}} over this repository using prbot, an automated tool.
+{{if .NumParts}}
+This is part {{.Part}} of {{.NumParts}} of the changes, which are split up to be easier to review.
+{{end}}
+{{.Report}}
+{{.FileList}}`
+
+// prBodyTmpl is the parsed -pr-body-template, or else defaultPRBody.
+var prBodyTmpl = template.Must(template.New("body").Parse(defaultPRBody))
+
+// prBodyText returns the body of the pull request that makes changes in github.com/owner/repo
+// against branch, from prBodyTmpl.
+// fixerDesc names the fixers that made the changes, and report,
+// if not empty, lists how many files each one changed.
+// part says which of several pull requests for the repo this is, if any.
+func prBodyText(owner, repo, branch, fixerDesc, report string, changes []github.TreeEntry, part prPart) (string, error) {
+	var paths []string
+	for _, te := range changes {
+		paths = append(paths, *te.Path)
+	}
+	sort.Strings(paths)
+	var buf bytes.Buffer
+	err := prBodyTmpl.Execute(&buf, struct {
+		Owner, Repo, Branch string
+		Fixer               string
+		FilesChanged        []string
+		NumFilesChanged     int
+		Report              string
+		FileList            string // FilesChanged as a Markdown list, collapsing all but the first few
+		Part, NumParts      int    // with -split-prs, this is pull request Part of NumParts; both are 0 otherwise
+	}{owner, repo, branch, fixerDesc, paths, len(paths), report, fileList(changes), part.N, part.Of})
+	if err != nil {
+		return "", fmt.Errorf("executing pull request body template: %v", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// A prPart says which of several pull requests for a repo one is.
+type prPart struct {
+	N, Of int    // with -split-prs, it is pull request N of Of
+	File  string // with -per-file-pr, the file it fixes
+}
+
+// String returns how the pull request's title refers to p, such as 2/3, or "" for none.
+func (p prPart) String() string {
+	if p.Of > 0 {
+		return fmt.Sprintf("%d/%d", p.N, p.Of)
+	}
+	return p.File
+}
+
+// prPause waits -pr-interval before the next of several pull requests for a repo,
+// so as not to trip GitHub's secondary rate limits, unless ctx is done first.
+func prPause(ctx context.Context) error {
+	if *prInterval <= 0 {
+		return nil
+	}
+	t := time.NewTimer(*prInterval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// newPullRequest is a github.NewPullRequest with the draft field,
+// which go-github doesn't know about.
+type newPullRequest struct {
+	github.NewPullRequest
+	Draft bool `json:"draft,omitempty"`
+}
+
+// createPullRequest makes the pull request pull in github.com/owner/repo.
+func createPullRequest(ctx context.Context, gh *github.Client, owner, repo string, pull *newPullRequest) (*github.PullRequest, error) {
+	u := fmt.Sprintf("repos/%v/%v/pulls", owner, repo)
+	req, err := gh.NewRequest("POST", u, pull)
+	if err != nil {
+		return nil, err
+	}
+	if pull.Draft {
+		// Older versions of GitHub Enterprise only accept drafts with this preview.
+		req.Header.Set("Accept", "application/vnd.github.shadow-cat-preview+json")
+	}
+	pr := new(github.PullRequest)
+	if _, err := gh.Do(ctx, req, pr); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// issueRE matches the -link-issue issues.
+var issueRE = regexp.MustCompile(`^[\w.-]+/[\w.-]+#[0-9]+$`)
+
+// issueLinks returns the lines of a pull request body that refer to the -link-issue issues.
+func issueLinks() string {
+	// GitHub closes issues on merging pull requests that say they close or fix them.
+	verb := map[string]string{"ref": "Ref", "closes": "Closes", "fixes": "Fixes"}[*linkIssueAction]
+	var buf strings.Builder
+	for _, issue := range linkIssues {
+		fmt.Fprintf(&buf, "%s %s\n", verb, issue)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// chunk splits changes into slices of at most n entries.
+func chunk(changes []github.TreeEntry, n int) [][]github.TreeEntry {
+	var parts [][]github.TreeEntry
+	for len(changes) > n {
+		parts = append(parts, changes[:n:n])
+		changes = changes[n:]
+	}
+	return append(parts, changes)
+}
+
+// branchSafe turns a file path into something that can go in a branch name,
+// replacing everything but letters, digits, '-' and '_' with '-'.
+func branchSafe(path string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, path)
+}
+
+// quota counts down the pull requests that may still be made.
+// A nil *quota has no limit.
+type quota struct {
+	left int64
+}
+
+func newQuota(n int) *quota {
+	return &quota{left: int64(n)}
+}
+
+// take reports whether another pull request may be made,
+// counting it against q if so.
+func (q *quota) take() bool {
+	if q == nil {
+		return true
+	}
+	if atomic.AddInt64(&q.left, -1) < 0 {
+		atomic.AddInt64(&q.left, 1)
+		return false
+	}
+	return true
+}
+
+// put gives back a pull request taken from q that wasn't made after all.
+func (q *quota) put() {
+	if q != nil {
+		atomic.AddInt64(&q.left, 1)
+	}
+}
+
+// forkOwner returns the owner of the repo that pull request branches
+// for github.com/owner/repo go in: the -existing-fork owner or -fork-org organization,
+// owner itself with -no-fork, or else the authenticated user.
+func forkOwner(ctx context.Context, gh *github.Client, owner string) (string, error) {
+	switch {
+	case *existingFork != "":
+		return *existingFork, nil
+	case *forkOrg != "":
+		return *forkOrg, nil
+	case *noFork:
+		return owner, nil
+	}
+	return authenticatedLogin(ctx, gh)
+}
+
+// authenticatedLogin returns the login of the user that gh is authenticated as.
+func authenticatedLogin(ctx context.Context, gh *github.Client) (string, error) {
+	me, _, err := gh.Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("getting authenticated user: %v", err)
+	}
+	return me.GetLogin(), nil
+}
+
+// checkPush returns an error if the authenticated user may not push to r,
+// as GitHub reported the repo to it. Some tokens, such as those of GitHub Apps,
+// aren't told their permissions, which passes.
+func checkPush(r *github.Repository) error {
+	if r.Permissions != nil && !r.GetPermissions()["push"] {
+		return fmt.Errorf("no permission to push to github.com/%s; a token that can push is needed to commit there", r.GetFullName())
+	}
+	return nil
+}
+
+// isPRBranch reports whether ref is the name of a branch prbot makes pull requests from,
+// with any of the suffixes added by -pr-branch-timestamp, -split-prs and -per-file-pr.
+func isPRBranch(ref string) bool {
+	return ref == *prBranchFlag || strings.HasPrefix(ref, *prBranchFlag+"-")
+}
+
+// prbotPRs returns the pull requests in github.com/owner/repo in state, which is open, closed or all,
+// that prbot made, along with the owner of the repo that their branches are in.
+func prbotPRs(ctx context.Context, gh *github.Client, owner, repo, state string) ([]*github.PullRequest, string, error) {
+	login, err := forkOwner(ctx, gh, owner)
+	if err != nil {
+		return nil, "", err
+	}
+	var mine []*github.PullRequest
+	opt := &github.PullRequestListOptions{
+		State:       state,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		var prs []*github.PullRequest
+		var resp *github.Response
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			prs, resp, err = gh.PullRequests.List(ctx, owner, repo, opt)
+			return err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("listing pull requests: %v", err)
+		}
+		for _, pr := range prs {
+			head := pr.GetHead()
+			if strings.EqualFold(head.GetRepo().GetOwner().GetLogin(), login) && isPRBranch(head.GetRef()) {
+				mine = append(mine, pr)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return mine, login, nil
+}
+
+// closeStalePRs closes the pull requests that prbot has open in github.com/owner/repo,
+// leaving the -close-stale-pr-comment comment on each, and deletes their branches.
+func closeStalePRs(ctx context.Context, gh *github.Client, owner, repo string) error {
+	lg := slog.With("repo", owner+"/"+repo)
+	stale, login, err := prbotPRs(ctx, gh, owner, repo, "open")
+	if err != nil {
+		return err
+	}
+
+	for _, pr := range stale {
+		lg.Info("Closing stale pull request", "url", pr.GetHTMLURL())
+		if *closeStaleComment != "" {
+			_, _, err := gh.Issues.CreateComment(ctx, owner, repo, pr.GetNumber(), &github.IssueComment{
+				Body: github.String(*closeStaleComment),
+			})
+			if err != nil {
+				lg.Warn("Commenting on stale pull request", "url", pr.GetHTMLURL(), "err", err)
+			}
+		}
+		_, _, err := gh.PullRequests.Edit(ctx, owner, repo, pr.GetNumber(), &github.PullRequest{
+			State: github.String("closed"),
+		})
+		if err != nil {
+			return fmt.Errorf("closing %s: %v", pr.GetHTMLURL(), err)
+		}
+		head := pr.GetHead()
+		if _, err := gh.Git.DeleteRef(ctx, login, head.GetRepo().GetName(), "refs/heads/"+head.GetRef()); err != nil {
+			lg.Warn("Deleting stale branch", "branch", head.GetRef(), "err", err)
+		}
+	}
+	return nil
+}
+
+// deleteMergedBranches deletes the branches of those of prs, prbot's pull requests
+// in github.com/owner/repo, that have been merged.
+// Branches that have moved on since, for a newer pull request, are left alone.
+func deleteMergedBranches(ctx context.Context, gh *github.Client, owner, repo string, prs []*github.PullRequest) error {
+	lg := slog.With("repo", owner+"/"+repo)
+	for _, pr := range prs {
+		head := pr.GetHead()
+		if pr.MergedAt == nil || head.GetRepo() == nil {
+			// Unmerged, or the fork has been deleted already.
+			continue
+		}
+		headOwner, headRepo := head.GetRepo().GetOwner().GetLogin(), head.GetRepo().GetName()
+		var ref *github.Reference
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			ref, _, err = gh.Git.GetRef(ctx, headOwner, headRepo, "refs/heads/"+head.GetRef())
+			return err
+		})
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("getting branch %s: %v", head.GetRef(), err)
+		}
+		if ref.GetObject().GetSHA() != head.GetSHA() {
+			lg.Info("Leaving alone branch of merged pull request, since it has moved on", "url", pr.GetHTMLURL(), "branch", head.GetRef())
+			continue
+		}
+		lg.Info("Deleting branch of merged pull request", "url", pr.GetHTMLURL(), "branch", head.GetRef())
+		err = withRetry(ctx, *maxRetries, func() (err error) {
+			_, err = gh.Git.DeleteRef(ctx, headOwner, headRepo, "refs/heads/"+head.GetRef())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("deleting branch %s: %v", head.GetRef(), err)
+		}
+	}
+	return nil
+}
+
+// reportPRs logs the state of prbot's pull requests in github.com/owner/repo, for -check-prs,
+// deleting the branches of the merged ones with -cleanup-branch.
+func reportPRs(ctx context.Context, gh *github.Client, owner, repo string) error {
+	lg := slog.With("repo", owner+"/"+repo)
+	prs, _, err := prbotPRs(ctx, gh, owner, repo, "all")
+	if err != nil {
+		return err
+	}
+	if len(prs) == 0 {
+		lg.Info("No pull requests from prbot")
+	}
+	for _, pr := range prs {
+		state := pr.GetState()
+		if pr.MergedAt != nil {
+			state = "merged"
+		}
+		lg.Info("Found pull request", "url", pr.GetHTMLURL(), "state", state, "branch", pr.GetHead().GetRef())
+	}
+	if *cleanupBranch {
+		return deleteMergedBranches(ctx, gh, owner, repo, prs)
+	}
+	return nil
+}
+
+// addLabels adds the labels want, such as the -label labels, to pull request or issue number in owner/repo.
+// Labels that don't exist in the repo are created if -create-missing-labels is set,
+// and are otherwise skipped.
+func addLabels(ctx context.Context, gh *github.Client, owner, repo string, number int, want []string) error {
+	var names []string
+	for _, name := range want {
+		_, _, err := gh.Issues.GetLabel(ctx, owner, repo, name)
+		if isNotFound(err) && *createLabels {
+			if err := createLabel(ctx, gh, owner, repo, name); err != nil {
+				return err
+			}
+		} else if isNotFound(err) {
+			slog.Warn("Not adding missing label; use -create-missing-labels to create it", "repo", owner+"/"+repo, "label", name)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("getting label %q: %v", name, err)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	// Pull requests are issues too, as far as labels are concerned.
+	_, _, err := gh.Issues.AddLabelsToIssue(ctx, owner, repo, number, names)
+	return err
+}
+
+// checkBranchProtection warns, for -check-branch-protection, if pull requests into branch of owner/repo
+// need approving reviews before they can be merged, which prbot's own can't give.
+// Branches that aren't protected are fine.
+func checkBranchProtection(ctx context.Context, gh *github.Client, owner, repo, branch string) error {
+	var p *github.Protection
+	err := withRetry(ctx, *maxRetries, func() (err error) {
+		p, _, err = gh.Repositories.GetBranchProtection(ctx, owner, repo, branch)
+		return err
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r := p.RequiredPullRequestReviews; r != nil && r.RequiredApprovingReviewCount > 0 {
+		slog.Warn("Branch needs approving reviews before pull requests can be merged", "repo", owner+"/"+repo, "branch", branch,
+			"reviews", r.RequiredApprovingReviewCount, "code_owners", r.RequireCodeOwnerReviews)
+	}
+	return nil
+}
+
+// createLabel creates the label name in owner/repo.
+func createLabel(ctx context.Context, gh *github.Client, owner, repo, name string) error {
+	slog.Info("Creating label", "repo", owner+"/"+repo, "label", name)
+	_, _, err := gh.Issues.CreateLabel(ctx, owner, repo, &github.Label{
+		Name:  github.String(name),
+		Color: github.String("ededed"), // GitHub's default label color
+	})
+	if err != nil {
+		return fmt.Errorf("creating label %q: %v", name, err)
+	}
+	return nil
+}
+
+// setMilestone puts pull request number in owner/repo in the -pr-milestone milestone,
+// which is either a milestone number or the title of one.
+// A milestone with that title is created if -pr-milestone-create is set.
+func setMilestone(ctx context.Context, gh *github.Client, owner, repo string, number int) error {
+	n, err := strconv.Atoi(*prMilestone)
+	if err != nil {
+		n, err = findMilestone(ctx, gh, owner, repo, *prMilestone)
+		if err != nil {
+			return err
+		}
+	}
+	// Pull requests are issues too, as far as milestones are concerned.
+	_, _, err = gh.Issues.Edit(ctx, owner, repo, number, &github.IssueRequest{Milestone: &n})
+	return err
+}
+
+// findMilestone returns the number of the milestone in owner/repo with the given title,
+// creating it if it's missing and -pr-milestone-create is set.
+func findMilestone(ctx context.Context, gh *github.Client, owner, repo, title string) (int, error) {
+	opt := &github.MilestoneListOptions{
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		ms, resp, err := gh.Issues.ListMilestones(ctx, owner, repo, opt)
+		if err != nil {
+			return 0, fmt.Errorf("listing milestones: %v", err)
+		}
+		for _, m := range ms {
+			if m.GetTitle() == title {
+				return m.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if !*createMilestone {
+		return 0, fmt.Errorf("no milestone %q; use -pr-milestone-create to create it", title)
+	}
+	slog.Info("Creating milestone", "repo", owner+"/"+repo, "milestone", title)
+	m, _, err := gh.Issues.CreateMilestone(ctx, owner, repo, &github.Milestone{Title: github.String(title)})
+	if err != nil {
+		return 0, fmt.Errorf("creating milestone %q: %v", title, err)
+	}
+	return m.GetNumber(), nil
+}
+
+// isNotFound reports whether err is a 404 response from the GitHub API.
+func isNotFound(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusNotFound
+}
+
+// addAssignees assigns pull request number in owner/repo to the -assignee users.
+// The special assignee "@self" stands for author, the user prbot is authenticated as.
+func addAssignees(ctx context.Context, gh *github.Client, owner, repo string, number int, author string) error {
+	var users []string
+	for _, a := range assignees {
+		if a == "@self" {
+			a = author
+		}
+		users = append(users, a)
+	}
+	_, _, err := gh.Issues.AddAssignees(ctx, owner, repo, number, users)
+	return err
+}
+
+// requestReviewers requests reviews of pull request number in owner/repo
+// from users and teams. A reviewer of the form "org/team"
+// is a team; anything else is a user. The author of the pull request
+// cannot review it, so they are skipped.
+func requestReviewers(ctx context.Context, gh *github.Client, owner, repo string, number int, author string, users []string) error {
+	var req github.ReviewersRequest
+	for _, r := range users {
+		if i := strings.Index(r, "/"); i >= 0 {
+			req.TeamReviewers = append(req.TeamReviewers, r[i+1:])
+		} else if strings.EqualFold(r, author) {
+			slog.Warn("Not requesting a review from the pull request author", "repo", owner+"/"+repo, "reviewer", r)
+		} else {
+			req.Reviewers = append(req.Reviewers, r)
+		}
+	}
+	if len(req.Reviewers) == 0 && len(req.TeamReviewers) == 0 {
+		return nil
+	}
+	_, _, err := gh.PullRequests.RequestReviewers(ctx, owner, repo, number, req)
+	return err
+}
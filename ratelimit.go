@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter paces requests to the GitHub API.
+// It enforces a fixed request rate, and additionally holds
+// all requests back once GitHub reports that the quota is used up.
+type rateLimiter struct {
+	lim *rate.Limiter
+
+	mu    sync.Mutex
+	reset time.Time // when the quota was last reported as being replenished
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{
+		lim: rate.NewLimiter(rate.Limit(rps), 1),
+	}
+}
+
+// wait blocks until another request may be made, or ctx is done.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	rl.mu.Lock()
+	reset := rl.reset
+	rl.mu.Unlock()
+	if d := time.Until(reset); d > 0 {
+		slog.Warn("Rate limit exhausted; sleeping until it resets", "delay", d.Round(time.Second))
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return rl.lim.Wait(ctx)
+}
+
+// update records the rate limit state reported in a response
+// (the X-RateLimit-Remaining and X-RateLimit-Reset headers).
+func (rl *rateLimiter) update(resp *github.Response) {
+	if resp == nil || resp.Rate.Limit == 0 || resp.Rate.Remaining > 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if reset := resp.Rate.Reset.Time; reset.After(rl.reset) {
+		rl.reset = reset
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// withRetry calls fn, and retries it up to n more times
+// while it fails with what looks like a transient error.
+// It waits a second before the first retry, doubling that each time,
+// unless ctx is done first, when it returns ctx's error.
+func withRetry(ctx context.Context, n int, fn func() error) error {
+	delay := time.Second
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= n || !isTransient(err) {
+			return err
+		}
+		slog.Warn("Retrying after error", "delay", delay, "err", err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// isTransient reports whether err is the kind of error
+// that may go away if the request is made again:
+// a network error or a 5xx response from GitHub.
+func isTransient(err error) bool {
+	switch err := err.(type) {
+	case *github.ErrorResponse:
+		return err.Response != nil && err.Response.StatusCode >= 500
+	case *url.Error:
+		if err.Err == context.Canceled || err.Err == context.DeadlineExceeded {
+			return false
+		}
+		return true
+	case net.Error:
+		return true
+	}
+	return false
+}
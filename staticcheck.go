@@ -0,0 +1,49 @@
+package main
+
+import (
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log/slog"
+
+	"golang.org/x/tools/go/analysis"
+	"honnef.co/go/tools/simple"
+)
+
+// StaticcheckFixer applies the suggested fixes
+// of staticcheck's simplification checks (S1000, S1001, etc.).
+// Since it needs type information, it leaves alone any file
+// that does not type-check by itself against the standard library.
+type StaticcheckFixer struct{}
+
+func (StaticcheckFixer) Name() string { return "staticcheck" }
+
+func (StaticcheckFixer) Fix(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	files := []*ast.File{f}
+	pkg, info, err := typeCheck(fset, files)
+	if err != nil {
+		return src, nil
+	}
+
+	run := newAnalysisRun(fset, files, pkg, info)
+	var diags []analysis.Diagnostic
+	for _, a := range simple.Analyzers {
+		d, err := run.diagnostics(a.Analyzer)
+		if err != nil {
+			slog.Warn("Skipping staticcheck analyzer", "path", filename, "analyzer", a.Analyzer.Name, "err", err)
+			continue
+		}
+		diags = append(diags, d...)
+	}
+	out, n := applyFixes(fset.File(f.Pos()), src, diags)
+	if n == 0 {
+		return src, nil
+	}
+	return format.Source(out)
+}
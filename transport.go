@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// apiVersionTransport sets the X-GitHub-Api-Version header of each request,
+// pinning the version of the GitHub REST API, for -github-api-version.
+type apiVersionTransport struct {
+	base    http.RoundTripper
+	version string
+}
+
+func (t apiVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't change the request they are given.
+	req = req.Clone(req.Context())
+	req.Header.Set("X-GitHub-Api-Version", t.version)
+	return t.base.RoundTrip(req)
+}
+
+// maxTracedBody is how much of each body -trace=body logs.
+const maxTracedBody = 4 << 10
+
+// traceTransport logs each request, for -trace, and with bodies set,
+// the start of the request and response bodies too.
+type traceTransport struct {
+	base   http.RoundTripper
+	bodies bool
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	args := []any{"method", req.Method, "url", req.URL.String()}
+	if t.bodies && req.Body != nil && req.GetBody != nil {
+		// Read a copy, leaving the body itself to be sent.
+		if body, err := req.GetBody(); err == nil {
+			args = append(args, "request_body", tracedBody(body))
+			body.Close()
+		}
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	args = append(args, "duration", time.Since(start))
+	if err != nil {
+		slog.Info("HTTP request failed", append(args, "err", err)...)
+		return resp, err
+	}
+	args = append(args, "status", resp.StatusCode)
+	if t.bodies {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var body io.Reader = bytes.NewReader(data)
+		if err != nil {
+			// Whoever reads the body gets the error too.
+			body = io.MultiReader(body, errReader{err})
+		}
+		resp.Body = io.NopCloser(body)
+		args = append(args, "response_body", truncateBody(data))
+	}
+	slog.Info("HTTP request", args...)
+	return resp, nil
+}
+
+func tracedBody(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, maxTracedBody+1))
+	return truncateBody(data)
+}
+
+func truncateBody(data []byte) string {
+	if len(data) > maxTracedBody {
+		return string(data[:maxTracedBody]) + "..."
+	}
+	return string(data)
+}
+
+// errReader is an io.Reader that always fails with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
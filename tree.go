@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-github/github"
+)
+
+// fetchTree returns the whole tree of sha, a commit or tree in github.com/owner/repo,
+// with the entries of all its subtrees.
+//
+// GitHub truncates recursive trees with too many entries,
+// so for those each subtree is fetched in turn, recursively where it can be,
+// going down at most -max-tree-depth directories. Deeper ones are left out.
+func fetchTree(ctx context.Context, gh *github.Client, owner, repo, sha string) (*github.Tree, error) {
+	lg := slog.With("repo", owner+"/"+repo)
+	get := func(sha string, recursive bool) (*github.Tree, error) {
+		var tree *github.Tree
+		err := withRetry(ctx, *maxRetries, func() (err error) {
+			tree, _, err = gh.Git.GetTree(ctx, owner, repo, sha, recursive)
+			return err
+		})
+		return tree, err
+	}
+	tree, err := get(sha, true)
+	if err != nil || !tree.GetTruncated() {
+		return tree, err
+	}
+	lg.Info("Tree is too big to fetch at once; fetching it a directory at a time", "sha", tree.GetSHA())
+
+	// add appends the entries of t, the recursive tree at dir, to all.
+	// If t is truncated, its subtrees are fetched one by one instead.
+	var add func(all []github.TreeEntry, t *github.Tree, dir string, depth int) ([]github.TreeEntry, error)
+	add = func(all []github.TreeEntry, t *github.Tree, dir string, depth int) ([]github.TreeEntry, error) {
+		split := t.GetTruncated()
+		if split {
+			var err error
+			if t, err = get(t.GetSHA(), false); err != nil {
+				return nil, fmt.Errorf("getting tree of %s: %v", dir, err)
+			}
+		}
+		for _, te := range t.Entries {
+			if dir != "" {
+				te.Path = github.String(dir + "/" + te.GetPath())
+			}
+			all = append(all, te)
+			if !split || te.GetType() != "tree" {
+				continue
+			}
+			if depth >= *maxTreeDepth {
+				lg.Warn("Skipping directory because it is deeper than -max-tree-depth", "path", te.GetPath(), "max", *maxTreeDepth)
+				continue
+			}
+			sub, err := get(te.GetSHA(), true)
+			if err != nil {
+				return nil, fmt.Errorf("getting tree of %s: %v", te.GetPath(), err)
+			}
+			if all, err = add(all, sub, te.GetPath(), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return all, nil
+	}
+	all, err := add(nil, tree, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	tree.Entries = all
+	tree.Truncated = github.Bool(false)
+	return tree, nil
+}
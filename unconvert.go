@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// UnconvertFixer removes conversions of values to the type they already have,
+// like the unconvert tool, turning T(x) into x where x is of type T.
+// Like staticcheck, it only covers packages that type-check against the standard library,
+// so that the type of every operand is known.
+//
+// Conversions that might be needed on another platform are left alone:
+// those of operands that use anything from package syscall, whose types vary,
+// and those whose removal would leave an import unused.
+type UnconvertFixer struct{}
+
+func (UnconvertFixer) Name() string { return "unconvert" }
+
+func (UnconvertFixer) FixPackage(files map[string][]byte) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parsePackages(fset, files)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	for _, pfiles := range pkgs {
+		_, info, err := typeCheck(fset, pfiles)
+		if err != nil {
+			continue
+		}
+		for _, f := range pfiles {
+			convs := unneededConversions(info, f)
+			if len(convs) == 0 {
+				continue
+			}
+			f = astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+				call, ok := c.Node().(*ast.CallExpr)
+				if !ok || !convs[call] {
+					return true
+				}
+				x := call.Args[0]
+				if needsParens(x, call, c.Parent()) {
+					x = &ast.ParenExpr{Lparen: call.Pos(), X: x, Rparen: call.End() - 1}
+				}
+				c.Replace(x)
+				return true
+			}).(*ast.File)
+			var buf bytes.Buffer
+			if err := format.Node(&buf, fset, f); err != nil {
+				return nil, err
+			}
+			out[fset.File(f.Pos()).Name()] = buf.Bytes()
+		}
+	}
+	return out, nil
+}
+
+// unneededConversions returns the conversions in f that can be removed.
+func unneededConversions(info *types.Info, f *ast.File) map[*ast.CallExpr]bool {
+	// How often each import is used, so as not to remove the last use of one.
+	pkgUses := make(map[*types.PkgName]int)
+	countUses := func(n ast.Node, uses map[*types.PkgName]int) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if pn, ok := info.Uses[id].(*types.PkgName); ok {
+					uses[pn]++
+				}
+			}
+			return true
+		})
+	}
+	countUses(f, pkgUses)
+
+	var calls []*ast.CallExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && isUnneededConversion(info, f, call) {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Pos() < calls[j].Pos() })
+
+	convs := make(map[*ast.CallExpr]bool)
+	for _, call := range calls {
+		removed := make(map[*types.PkgName]int)
+		countUses(call.Fun, removed)
+		ok := true
+		for pn, n := range removed {
+			ok = ok && pkgUses[pn] > n
+		}
+		if !ok {
+			continue
+		}
+		for pn, n := range removed {
+			pkgUses[pn] -= n
+		}
+		convs[call] = true
+	}
+	return convs
+}
+
+// isUnneededConversion reports whether call, in f, converts a value to the type it already has.
+func isUnneededConversion(info *types.Info, f *ast.File, call *ast.CallExpr) bool {
+	if len(call.Args) != 1 || call.Ellipsis.IsValid() || !info.Types[call.Fun].IsType() {
+		return false
+	}
+	x := call.Args[0]
+	if _, ok := x.(*ast.CompositeLit); ok {
+		// T{} can't stand alone in the header of an if, for or switch statement, as T(T{}) can.
+		return false
+	}
+	to, from := info.Types[call].Type, info.Types[x].Type
+	if to == nil || from == nil || !types.Identical(to, from) {
+		return false
+	}
+	// Converting an untyped value gives it its type,
+	// which go/types records as the type of the value itself.
+	if maybeUntyped(info, x) {
+		return false
+	}
+	// Comments in the type would be lost.
+	for _, cg := range f.Comments {
+		if cg.Pos() < call.End() && call.Pos() < cg.End() && !(x.Pos() <= cg.Pos() && cg.End() <= x.End()) {
+			return false
+		}
+	}
+	platform := false
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if obj := info.Uses[id]; obj != nil && obj.Pkg() != nil && obj.Pkg().Path() == "syscall" {
+				platform = true
+			}
+		}
+		return !platform
+	})
+	return !platform
+}
+
+// maybeUntyped reports whether x might be untyped, such as a constant, nil or a comparison,
+// before it is converted.
+func maybeUntyped(info *types.Info, x ast.Expr) bool {
+	tv := info.Types[x]
+	if tv.IsNil() {
+		return true
+	}
+	if tv.Value != nil {
+		// Only a constant declared with a type is sure to keep it.
+		var id *ast.Ident
+		switch x := x.(type) {
+		case *ast.Ident:
+			id = x
+		case *ast.SelectorExpr:
+			id = x.Sel
+		}
+		c, ok := info.Uses[id].(*types.Const)
+		return !ok || !types.Identical(c.Type(), tv.Type)
+	}
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return maybeUntyped(info, x.X)
+	case *ast.UnaryExpr:
+		return maybeUntyped(info, x.X)
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return true
+		case token.SHL, token.SHR:
+			return maybeUntyped(info, x.X)
+		}
+		return maybeUntyped(info, x.X) && maybeUntyped(info, x.Y)
+	}
+	return false
+}
+
+// needsParens reports whether x, the operand of conv, a conversion that is removed,
+// needs parentheses to keep its meaning where conv was, in parent.
+func needsParens(x ast.Expr, conv *ast.CallExpr, parent ast.Node) bool {
+	var prec int
+	switch x := x.(type) {
+	case *ast.BinaryExpr:
+		prec = x.Op.Precedence()
+	case *ast.UnaryExpr, *ast.StarExpr:
+		prec = token.UnaryPrec
+	default:
+		return false
+	}
+	switch p := parent.(type) {
+	case *ast.BinaryExpr:
+		return prec <= p.Op.Precedence()
+	case *ast.UnaryExpr, *ast.StarExpr:
+		return prec < token.UnaryPrec
+	// As an index or an argument, x stands alone; only as an operand does it need them.
+	case *ast.SelectorExpr:
+		return p.X == conv
+	case *ast.IndexExpr:
+		return p.X == conv
+	case *ast.IndexListExpr:
+		return p.X == conv
+	case *ast.SliceExpr:
+		return p.X == conv
+	case *ast.TypeAssertExpr:
+		return p.X == conv
+	case *ast.CallExpr:
+		return p.Fun == conv
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import "testing"
+
+func TestUnconvertFixer(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string // "" if the file is left alone
+	}{
+		{
+			name: "same type",
+			src: `package p
+
+func f(s string) string {
+	return string(s)
+}
+`,
+			want: `package p
+
+func f(s string) string {
+	return s
+}
+`,
+		},
+		{
+			name: "parentheses needed",
+			src: `package p
+
+func f(a, b int) int {
+	return int(a+b) * 2
+}
+`,
+			want: `package p
+
+func f(a, b int) int {
+	return (a + b) * 2
+}
+`,
+		},
+		{
+			name: "different type",
+			src: `package p
+
+func f(n int32) int64 {
+	return int64(n)
+}
+`,
+		},
+		{
+			name: "untyped constant",
+			src: `package p
+
+type T int
+
+var x = T(5)
+`,
+		},
+		{
+			name: "typed constant",
+			src: `package p
+
+type T int
+
+const c T = 5
+
+var x = T(c)
+`,
+			want: `package p
+
+type T int
+
+const c T = 5
+
+var x = c
+`,
+		},
+		{
+			name: "syscall",
+			src: `package p
+
+import "syscall"
+
+func f(st *syscall.Stat_t) uint64 {
+	return uint64(st.Size) + uint64(st.Ino)
+}
+`,
+		},
+		{
+			name: "last use of an import",
+			src: `package p
+
+import (
+	"io/fs"
+	"os"
+)
+
+func f(fi os.FileInfo) any {
+	return fs.FileMode(fi.Mode())
+}
+`,
+		},
+		{
+			name: "comment in the type",
+			src: `package p
+
+func f(s string) string {
+	return string /* already */ (s)
+}
+`,
+		},
+		{
+			name: "imports another module",
+			src: `package p
+
+import "example.com/other"
+
+func f(s string) string {
+	other.Use()
+	return string(s)
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnconvertFixer{}.FixPackage(map[string][]byte{"p/a.go": []byte(tt.src)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{}
+			if tt.want != "" {
+				want["p/a.go"] = tt.want
+			}
+			checkPackageFix(t, got, want)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// maxPayload is the largest webhook payload that GitHub sends.
+const maxPayload = 25 << 20
+
+// A webhookServer handles GitHub push events for -webhook-server,
+// processing each repo pushed to in the background.
+// A repo is queued at most once at a time, and is never processed twice at once.
+type webhookServer struct {
+	secret  []byte
+	queue   chan pushedRepo
+	process func(ctx context.Context, owner, repo, branch string)
+
+	mu     sync.Mutex
+	queued map[string]bool      // keyed by owner/repo
+	locks  map[string]*repoLock // held while a repo is processed, keyed by owner/repo
+}
+
+// A repoLock is held while a repo is processed.
+// It is dropped from webhookServer.locks once no worker holds or waits for it.
+type repoLock struct {
+	sync.Mutex
+	refs int // the workers holding or waiting for it, guarded by webhookServer.mu
+}
+
+type pushedRepo struct {
+	owner, repo, branch string
+}
+
+func (p pushedRepo) key() string { return p.owner + "/" + p.repo }
+
+// newWebhookServer returns a webhookServer that checks payloads are signed with secret,
+// holds up to depth repos waiting to be processed, and processes them with process.
+func newWebhookServer(secret []byte, depth int, process func(ctx context.Context, owner, repo, branch string)) *webhookServer {
+	return &webhookServer{
+		secret:  secret,
+		queue:   make(chan pushedRepo, depth),
+		process: process,
+		queued:  make(map[string]bool),
+		locks:   make(map[string]*repoLock),
+	}
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "webhooks are POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPayload)
+	payload, err := github.ValidatePayload(r, s.secret)
+	if err != nil {
+		slog.Warn("Bad webhook request", "remote_addr", r.RemoteAddr, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch event := github.WebHookType(r); event {
+	case "ping":
+		// GitHub sends this when the webhook is set up.
+		fmt.Fprintln(w, "pong")
+		return
+	case "push":
+	default:
+		slog.Debug("Ignoring webhook event", "event", event)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var ev github.PushEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		http.Error(w, "bad push event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The owner in push events has no login, so it comes from the full name.
+	owner, repo, ok := strings.Cut(ev.GetRepo().GetFullName(), "/")
+	branch := strings.TrimPrefix(ev.GetRef(), "refs/heads/")
+	if !ok || ev.GetDeleted() || branch != ev.GetRepo().GetDefaultBranch() {
+		// Only the default branch gets pull requests, not tags or other branches.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	p := pushedRepo{owner, repo, branch}
+	if !s.enqueue(p) {
+		slog.Warn("Webhook queue full; dropping push", "repo", p.key())
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("Queued pushed repo", "repo", p.key(), "branch", branch)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueue queues p to be processed, unless it is already waiting,
+// reporting whether there was room.
+func (s *webhookServer) enqueue(p pushedRepo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued[p.key()] {
+		return true
+	}
+	select {
+	case s.queue <- p:
+		s.queued[p.key()] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// work processes queued repos until ctx is done.
+func (s *webhookServer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-s.queue:
+			s.mu.Lock()
+			// A push from now on needs the repo processed again.
+			delete(s.queued, p.key())
+			l := s.locks[p.key()]
+			if l == nil {
+				l = new(repoLock)
+				s.locks[p.key()] = l
+			}
+			l.refs++
+			s.mu.Unlock()
+
+			l.Lock()
+			s.process(ctx, p.owner, p.repo, p.branch)
+			l.Unlock()
+
+			s.mu.Lock()
+			if l.refs--; l.refs == 0 {
+				delete(s.locks, p.key())
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// serve listens on addr for webhooks, processing up to workers repos at once,
+// until ctx is done. It then waits for the repos being processed.
+func (s *webhookServer) serve(ctx context.Context, addr string, workers int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+	srv := &http.Server{Addr: addr, Handler: s}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	slog.Info("Listening for webhooks", "addr", addr)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	cancel() // in case the server failed to start
+	wg.Wait()
+	return err
+}